@@ -0,0 +1,257 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"archive/tar"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/cluster"
+	"github.com/shinexia/elasticdump/pkg/dumpdata"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/manifest"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdBackup(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		OutputFile    string
+		Compress      string
+		Batch         int
+		IncludeSystem bool
+	}
+	cfg := newBaseConfig()
+	cfg.Index = "*"
+	dopt := dumpdata.NewDumpDataOption()
+	extra := &extraOption{
+		OutputFile:    "",
+		Batch:         1000,
+		IncludeSystem: false,
+	}
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "backup indexes, templates and pipelines into one tar archive",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, opt: %s, extra: %s", helpers.ToJSON(cfg), helpers.ToJSON(dopt), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			if extra.OutputFile == "" {
+				extra.OutputFile = "elasticdump-backup.tar" + helpers.CompressionExt(extra.Compress)
+			}
+			extra.Compress, err = helpers.ResolveCompression(extra.Compress, extra.OutputFile)
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
+			if err != nil {
+				return err
+			}
+			indices, err := helpers.ResolveIndices(client, cfg.Index)
+			if err != nil {
+				return err
+			}
+			// tar needs the size of every file up front, so each index is dumped into a staging directory next to
+			// the archive, added to the archive and removed before the next one
+			tmpDir, err := os.MkdirTemp(filepath.Dir(extra.OutputFile), ".elasticdump-backup-")
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			defer func() {
+				err := os.RemoveAll(tmpDir)
+				if err != nil {
+					klog.V(4).Infof("remove staging dir failed: %v", err)
+				}
+			}()
+			m := &manifest.Manifest{CreatedAt: startTime}
+			bodies := map[string][]byte{}
+			for _, kind := range cluster.Kinds {
+				objects, err := cluster.GetObjects(client, kind, "")
+				if err != nil {
+					return err
+				}
+				names := make([]string, 0, len(objects))
+				for name, body := range objects {
+					if !extra.IncludeSystem && (strings.HasPrefix(name, ".") || cluster.IsManaged(body)) {
+						continue
+					}
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					obj := &manifest.Object{
+						Kind: string(kind),
+						Name: name,
+						File: path.Join(string(kind), url.PathEscape(name)+".json"),
+					}
+					bodies[obj.File] = objects[name]
+					m.Objects = append(m.Objects, obj)
+				}
+				klog.Infof("backup %d %s\n", len(names), kind)
+			}
+			for _, index := range indices {
+				m.Indices = append(m.Indices, &manifest.Index{
+					Name:    index,
+					Mapping: path.Join("indices", index+"-mapping.json"),
+					Data:    path.Join("indices", index+"-data.json"),
+				})
+			}
+			err = os.MkdirAll(filepath.Join(tmpDir, "indices"), 0755)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			writer, err := helpers.CreateFile(extra.OutputFile, extra.Compress)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := writer.Close()
+				if err != nil {
+					klog.V(4).Infof("close writer failed: %v", err)
+				}
+			}()
+			// the archive is written in restore order: the manifest, the objects, then the mapping and data of each index
+			tw := tar.NewWriter(writer)
+			err = addTarManifest(tw, m)
+			if err != nil {
+				return err
+			}
+			for _, obj := range m.Objects {
+				err = addTarEntry(tw, obj.File, bodies[obj.File])
+				if err != nil {
+					return err
+				}
+			}
+			for _, entry := range m.Indices {
+				err = dumpMapping(client, entry.Name, filepath.Join(tmpDir, entry.Mapping), helpers.CompressNone)
+				if err != nil {
+					return errors.WithMessagef(err, "index: %s", entry.Name)
+				}
+				err = moveTarFile(tw, entry.Mapping, filepath.Join(tmpDir, entry.Mapping))
+				if err != nil {
+					return err
+				}
+				ops := newSearchOptions(client, entry.Name, extra.Batch, dopt, dumpdata.QUERY_ALL, "")
+				entry.Count, err = dumpDataFile(client, dopt, filepath.Join(tmpDir, entry.Data), helpers.CompressNone, ops...)
+				if err != nil {
+					return errors.WithMessagef(err, "index: %s", entry.Name)
+				}
+				err = moveTarFile(tw, entry.Data, filepath.Join(tmpDir, entry.Data))
+				if err != nil {
+					return err
+				}
+				klog.Infof("backup index succeed, total: %d, index: %s\n", entry.Count, entry.Name)
+			}
+			// the counts are only known now, the manifest is repeated at the end so an extracted archive holds them
+			err = addTarManifest(tw, m)
+			if err != nil {
+				return err
+			}
+			err = tw.Close()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = writer.Close()
+			if err != nil {
+				return errors.Wrapf(err, "dest: %s", extra.OutputFile)
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("backup succeed, indices: %d, objects: %d, file: %s, cost: %.3fs\n", len(m.Indices), len(m.Objects), extra.OutputFile, cost)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	flagSet.Lookup("index").Usage = "elasticsearch index names and wildcard patterns, separated by comma"
+
+	flagSet.StringVarP(&extra.OutputFile, "file", "f", extra.OutputFile, "output archive, default elasticdump-backup.tar, the largest index is staged uncompressed next to it so that much free disk space is needed")
+	flagSet.StringVar(&extra.Compress, "compress", extra.Compress, "compress the archive: none, gzip or zstd, default by the file extension (.gz, .zst)")
+	flagSet.BoolVar(&extra.IncludeSystem, "include-system", extra.IncludeSystem, "also backup templates and pipelines managed by elasticsearch")
+
+	flagSet.IntVar(&dopt.TimeoutSec, "timeout", dopt.TimeoutSec, "timeout (second) when scroll, also the keep alive of point in time")
+	flagSet.StringVar(&dopt.Mode, "mode", dopt.Mode, "how to page through the index: scroll or pit (point in time with search_after)")
+	flagSet.IntVar(&dopt.Slices, "slices", dopt.Slices, "number of sliced scrolls read concurrently")
+	flagSet.IntVarP(&extra.Batch, "batch", "b", extra.Batch, "batch size when scroll")
+	return cmd
+}
+
+// addTarManifest writes the manifest m into the archive
+func addTarManifest(tw *tar.Writer, m *manifest.Manifest) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return addTarEntry(tw, manifest.FileName, data)
+}
+
+// addTarEntry writes data into the archive as name
+func addTarEntry(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return errors.Wrapf(err, "archive file: %s failed", name)
+	}
+	return nil
+}
+
+// moveTarFile writes the staged file into the archive as name, then removes it
+func moveTarFile(tw *tar.Writer, name, file string) error {
+	err := addTarFile(tw, name, file)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func addTarFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		// an index without documents leaves no data file
+		if os.IsNotExist(err) {
+			return tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 0, ModTime: time.Now()})
+		}
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: stat.Size(), ModTime: stat.ModTime()})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(tw, f)
+	if err != nil {
+		return errors.Wrapf(err, "archive file: %s failed", file)
+	}
+	return nil
+}
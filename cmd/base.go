@@ -11,6 +11,9 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/pkg/errors"
 	flag "github.com/spf13/pflag"
 	"k8s.io/klog"
@@ -20,6 +23,8 @@ type BaseConfig struct {
 	Host               string `json:"host"`
 	Index              string `json:"index"`
 	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CACert             string `json:"ca_cert"`
+	APIKey             string `json:"-"`
 }
 
 func addBaseConfigFlags(flagSet *flag.FlagSet, cfg *BaseConfig) {
@@ -27,6 +32,20 @@ func addBaseConfigFlags(flagSet *flag.FlagSet, cfg *BaseConfig) {
 	flagSet.StringVar(&cfg.Index, "index", cfg.Index, "elasticsearch index name")
 	flagSet.BoolVarP(&cfg.InsecureSkipVerify, "insecure-skip-verify", "k", cfg.InsecureSkipVerify, "skip verify tls certificate")
 
+	addKlogFlags(flagSet)
+}
+
+// addPrefixedConfigFlags adds the flags of cfg as --<prefix>-host, --<prefix>-index ...,
+// for commands talking to two clusters
+func addPrefixedConfigFlags(flagSet *flag.FlagSet, prefix string, cfg *BaseConfig) {
+	flagSet.StringVar(&cfg.Host, prefix+"-host", cfg.Host, prefix+" elasticsearch host: http://<user>:<password>@<host>:<port>")
+	flagSet.StringVar(&cfg.Index, prefix+"-index", cfg.Index, prefix+" elasticsearch index name")
+	flagSet.BoolVar(&cfg.InsecureSkipVerify, prefix+"-insecure-skip-verify", cfg.InsecureSkipVerify, "skip verify tls certificate of "+prefix+" elasticsearch")
+	flagSet.StringVar(&cfg.CACert, prefix+"-ca-cert", cfg.CACert, "ca certificate file (pem) to verify "+prefix+" elasticsearch")
+	flagSet.StringVar(&cfg.APIKey, prefix+"-api-key", cfg.APIKey, "api key of "+prefix+" elasticsearch, instead of the user and password of the host")
+}
+
+func addKlogFlags(flagSet *flag.FlagSet) {
 	klogSet := gflag.NewFlagSet(os.Args[0], gflag.ContinueOnError)
 	klog.InitFlags(klogSet)
 
@@ -61,6 +80,23 @@ func parseHost(inHost string) (host string, err error) {
 	return host, nil
 }
 
+// newElasticSearchClient creates a client with the credentials and tls options of cfg, extra options are appended
+func newElasticSearchClient(cfg *BaseConfig, extra ...elasticsearch.Option) (*elasticsearch.Client, error) {
+	var options []elasticsearch.Option
+	if cfg.CACert != "" {
+		cert, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read ca cert: %s failed", cfg.CACert)
+		}
+		options = append(options, elasticsearch.WithCACert(cert))
+	}
+	if cfg.APIKey != "" {
+		options = append(options, elasticsearch.WithAPIKey(cfg.APIKey))
+	}
+	options = append(options, extra...)
+	return helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify, options...)
+}
+
 func preprocessBaseConfig(cfg *BaseConfig) error {
 	host, err := parseHost(cfg.Host)
 	if err != nil {
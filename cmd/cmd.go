@@ -38,7 +38,35 @@ func NewElasticDumpCommand(in io.Reader, out, err io.Writer) *cobra.Command {
 
 				elasticdump --host http://localhost:9200 --index elasticdumptest load mapping --delete
 
+				elasticdump --host http://localhost:9200 --index elasticdumptest load mapping --target-version 8 --dry-run
+
+				elasticdump --host http://localhost:9200 --index elasticdumptest-v2 load mapping --file elasticdumptest-mapping.json --aliases-file elasticdumptest-aliases.json --aliases remap
+
 				elasticdump --host http://localhost:9200 --index elasticdumptest load data
+
+				elasticdump --host http://localhost:9200 --index 'logs-2026.*,elasticdumptest' dump indices --dir dump
+
+				elasticdump --host http://localhost:9200 load indices --dir dump
+
+				elasticdump --host http://localhost:9200 --index elasticdumptest dump data --max-docs-per-sec 1000 --throttle-file throttle.json
+				elasticdump --host http://localhost:9200 --index elasticdumptest load data --progress-interval 30 --stats-file load-stats.json
+				elasticdump --host http://localhost:9200 --index elasticdumptest load data --metrics-addr :9108
+
+				elasticdump --host http://localhost:9200 dump templates --name "logs-*" --file templates.json
+
+				elasticdump --host http://localhost:9200 load templates --file templates.json --if-exists skip
+
+				elasticdump --host http://localhost:9200 dump pipelines --file pipelines.json
+
+				elasticdump --host http://localhost:9200 load pipelines --file pipelines.json
+
+				elasticdump --host http://localhost:9200 --index elasticdumptest load data --pipeline enrich
+
+				elasticdump --host http://localhost:9200 backup --file backup.tar.zst
+
+				elasticdump --host http://localhost:9200 restore --file backup.tar.zst
+
+				elasticdump copy data --source-host http://localhost:9200 --source-index elasticdumptest --dest-host http://localhost:9201
 		`),
 		SilenceErrors: true,
 		SilenceUsage:  true,
@@ -48,6 +76,9 @@ func NewElasticDumpCommand(in io.Reader, out, err io.Writer) *cobra.Command {
 
 	cmds.AddCommand(newCmdDump(out))
 	cmds.AddCommand(newCmdLoad(out))
+	cmds.AddCommand(newCmdCopy(out))
+	cmds.AddCommand(newCmdBackup(out))
+	cmds.AddCommand(newCmdRestore(out))
 	cmds.AddCommand(newCmdDelete(out))
 	cmds.AddCommand(newCmdTest(out))
 
@@ -62,6 +93,9 @@ func newCmdDump(out io.Writer) *cobra.Command {
 	}
 	cmd.AddCommand(newCmdDumpMapping(out))
 	cmd.AddCommand(newCmdDumpData(out))
+	cmd.AddCommand(newCmdDumpIndices(out))
+	cmd.AddCommand(newCmdDumpTemplates(out))
+	cmd.AddCommand(newCmdDumpPipelines(out))
 	return cmd
 }
 
@@ -73,6 +107,19 @@ func newCmdLoad(out io.Writer) *cobra.Command {
 	}
 	cmd.AddCommand(newCmdLoadMapping(out))
 	cmd.AddCommand(newCmdLoadData(out))
+	cmd.AddCommand(newCmdLoadIndices(out))
+	cmd.AddCommand(newCmdLoadTemplates(out))
+	cmd.AddCommand(newCmdLoadPipelines(out))
+	return cmd
+}
+
+func newCmdCopy(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "copy",
+		Short: "copy data between elasticsearch indexes without intermediate files",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newCmdCopyData(out))
 	return cmd
 }
 
@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/dumpdata"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/loaddata"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdCopyData(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		SearchQuery string
+		SearchBody  string
+		FailedFile  string
+		QueueSize   int
+		QueueBytes  int64
+		Delete      bool
+		MetricsAddr string
+	}
+	src := newBaseConfig()
+	dst := newBaseConfig()
+	dst.Index = ""
+	dopt := dumpdata.NewDumpDataOption()
+	lopt := loaddata.NewLoadDataOption()
+	extra := &extraOption{
+		SearchQuery: dumpdata.QUERY_ALL,
+		SearchBody:  "",
+		QueueSize:   0,
+		QueueBytes:  256 * 1024 * 1024,
+		Delete:      false,
+	}
+	throttle := &throttleConfig{}
+	prog := newProgressConfig()
+	cmd := &cobra.Command{
+		Use:   "data",
+		Short: "copy data from one index to another, possibly on another cluster",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("source: %v, dest: %v, dump: %s, load: %s, extra: %s", helpers.ToJSON(src), helpers.ToJSON(dst), helpers.ToJSON(dopt), helpers.ToJSON(lopt), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(src)
+			if err != nil {
+				return err
+			}
+			err = preprocessBaseConfig(dst)
+			if err != nil {
+				return err
+			}
+			if dst.Index == "" {
+				dst.Index = src.Index
+			}
+			if src.Host == dst.Host && src.Index == dst.Index {
+				return errors.Errorf("source and dest are the same index: %s", src.Index)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			srcClient, err := newElasticSearchClient(src)
+			if err != nil {
+				return err
+			}
+			dstClient, err := newElasticSearchClient(dst, helpers.WithoutTransportRetry())
+			if err != nil {
+				return err
+			}
+			jobMetrics, stopMetrics, err := startMetrics(extra.MetricsAddr)
+			if err != nil {
+				return err
+			}
+			defer stopMetrics()
+			dopt.Metrics = jobMetrics.Stage("dump")
+			lopt.Metrics = jobMetrics.Stage("load")
+			limiter, stopLimiter := startLimiter(throttle)
+			defer stopLimiter()
+			lopt.Limiter = limiter
+			if extra.Delete {
+				err = deleteIndexIfExists(dstClient, dst.Index)
+				if err != nil {
+					return err
+				}
+			}
+			lopt.Index = dst.Index
+			if extra.FailedFile != "" {
+				failedWriter := loaddata.NewLazyFailedWriter(extra.FailedFile)
+				defer func() {
+					err := failedWriter.Close()
+					if err != nil {
+						klog.V(4).Infof("close failed writer failed: %v", err)
+					}
+				}()
+				lopt.OnFailed = failedWriter.Write
+			}
+			klog.V(5).Infof("copy data from index: %s to index: %s, batch: %v, workers: %v\n", src.Index, dst.Index, lopt.Batch, lopt.Workers)
+			total := countDocs(srcClient, src.Index, extra.SearchQuery, extra.SearchBody)
+			if dopt.Limit > 0 {
+				total = min(total, int64(dopt.Limit))
+			}
+			var finish func(error) error
+			lopt.Progress, finish = startProgress(prog, "copy "+src.Index+" to "+dst.Index, total)
+			queue := loaddata.NewBoundedDataQueue(extra.QueueSize, extra.QueueBytes, loaddata.HitSize)
+			jobMetrics.SetQueueDepth(queue.Len)
+			var (
+				ncount int
+				derr   error
+			)
+			done := make(chan struct{})
+			//  async read records from the source index
+			go func() {
+				defer close(done)
+				defer queue.Stop()
+				ops := newSearchOptions(srcClient, src.Index, lopt.Batch, dopt, extra.SearchQuery, extra.SearchBody)
+				ncount, derr = dumpdata.DumpData(srcClient, dopt, func(hits []json.RawMessage) (int, error) {
+					return loaddata.PushRawHits(queue, hits)
+				}, ops...)
+			}()
+			err = loaddata.LoadData(dstClient, queue, lopt)
+			// a failed load stops the queue, which ends the dump
+			<-done
+			if err != nil {
+				return finish(err)
+			}
+			if derr != nil {
+				return finish(derr)
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("copy data succeed, total: %d, source: %s, dest: %s, cost: %.3fs\n", ncount, src.Index, dst.Index, cost)
+			return finish(nil)
+		},
+		Args: cobra.NoArgs,
+	}
+	flagSet := cmd.Flags()
+	addPrefixedConfigFlags(flagSet, "source", src)
+	addPrefixedConfigFlags(flagSet, "dest", dst)
+	addKlogFlags(flagSet)
+
+	flagSet.IntVarP(&dopt.Limit, "limit", "l", dopt.Limit, "limit size when scroll")
+	flagSet.IntVar(&dopt.TimeoutSec, "timeout", dopt.TimeoutSec, "timeout (second) when scroll, also the keep alive of point in time")
+	flagSet.StringVar(&dopt.Mode, "mode", dopt.Mode, "how to page through the source index: scroll or pit (point in time with search_after)")
+	flagSet.IntVar(&dopt.Slices, "slices", dopt.Slices, "number of sliced scrolls read concurrently")
+	flagSet.BoolVar(&dopt.WithVersion, "with-version", dopt.WithVersion, "also read _version, _seq_no and _primary_term of every document")
+	flagSet.StringVarP(&extra.SearchQuery, "search_query", "q", extra.SearchQuery, "search query")
+	flagSet.StringVarP(&extra.SearchBody, "search_body", "d", extra.SearchBody, "search body")
+
+	flagSet.StringVar(&lopt.Action, "action", lopt.Action, "bulk action: index (overwrite), create, update, upsert (update with doc_as_upsert) or delete (by _id)")
+	flagSet.IntVarP(&lopt.Batch, "batch", "b", lopt.Batch, "documents per scroll page and max documents per bulk request, also capped by --batch-bytes and adapted by --adaptive")
+	flagSet.Int64Var(&lopt.BatchBytes, "batch-bytes", lopt.BatchBytes, "max bytes of one bulk request, a request rejected with 413 is split, 0 is unlimited")
+	flagSet.BoolVar(&lopt.Adaptive, "adaptive", lopt.Adaptive, "adapt the batch size starting from --batch: grow it while bulks are faster than --target-latency, shrink it on slow or rejected bulks")
+	flagSet.IntVar(&lopt.MinBatch, "min-batch", lopt.MinBatch, "min batch size with --adaptive")
+	flagSet.IntVar(&lopt.MaxBatch, "max-batch", lopt.MaxBatch, "max batch size with --adaptive")
+	flagSet.IntVar(&lopt.TargetLatencyMs, "target-latency", lopt.TargetLatencyMs, "target latency (millisecond) of a bulk request with --adaptive")
+	flagSet.IntVar(&lopt.Workers, "workers", lopt.Workers, "number of concurrent bulk requests")
+	flagSet.IntVar(&lopt.MaxRetries, "max-retries", lopt.MaxRetries, "max retries of documents and bulk requests rejected with 429/5xx")
+	flagSet.IntVar(&lopt.RetryBackoffMs, "retry-backoff", lopt.RetryBackoffMs, "initial backoff (millisecond) before a retry, doubled on each attempt")
+	flagSet.IntVar(&lopt.MaxRetryBackoffMs, "max-retry-backoff", lopt.MaxRetryBackoffMs, "max backoff (millisecond) before a retry")
+	flagSet.IntVar(&lopt.BulkTimeoutSec, "bulk-timeout", lopt.BulkTimeoutSec, "timeout (second) of a bulk request, a timed out request is retried with a smaller batch, 0 waits forever")
+	flagSet.StringVar(&lopt.Pipeline, "pipeline", lopt.Pipeline, "ingest pipeline to preprocess documents with")
+	flagSet.IntVar(&lopt.Version, "target-version", lopt.Version, "major elasticsearch version of the target, before 7 bulk metadata has a _type, 0 detects it from the cluster and assumes the latest version when the info api fails")
+	flagSet.StringVar(&lopt.DocType, "doc-type", lopt.DocType, "_type of documents without one when the target is before 7")
+	flagSet.StringVar(&lopt.VersionType, "version-type", lopt.VersionType, "external or external_gte: index documents with their dumped _version (see --with-version) and keep newer ones, requires --action index or delete")
+	flagSet.BoolVar(&lopt.KeepIndex, "keep-index", lopt.KeepIndex, "load every document into the index it was dumped from instead of --dest-index")
+	flagSet.StringVar(&extra.FailedFile, "failed-file", extra.FailedFile, "write documents failed to index to this file, it can be loaded again after fixing")
+	flagSet.IntVar(&extra.QueueSize, "queue-size", extra.QueueSize, "max number of documents buffered between source and dest, 0 is unlimited")
+	flagSet.Int64Var(&extra.QueueBytes, "queue-bytes", extra.QueueBytes, "max bytes of documents buffered between source and dest, 0 is unlimited")
+	flagSet.BoolVar(&extra.Delete, "delete", extra.Delete, "whether delete the dest index before copy")
+	addThrottleFlags(flagSet, throttle)
+	addProgressFlags(flagSet, prog)
+	addMetricsFlags(flagSet, &extra.MetricsAddr)
+	return cmd
+}
@@ -15,25 +15,38 @@ import (
 	"github.com/shinexia/elasticdump/pkg/dumpdata"
 	"github.com/shinexia/elasticdump/pkg/helpers"
 
+	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
 )
 
 func newCmdDumpData(_ io.Writer) *cobra.Command {
 	type extraOption struct {
-		OutputFile  string
-		Batch       int
-		SearchQuery string
-		SearchBody  string
+		OutputFile     string
+		Compress       string
+		Batch          int
+		SearchQuery    string
+		SearchBody     string
+		CheckpointFile string
+		CheckpointSec  int
+		PitKeepAlive   int
+		Resume         bool
+		MetricsAddr    string
 	}
 	cfg := newBaseConfig()
 	dopt := dumpdata.NewDumpDataOption()
 	extra := &extraOption{
-		Batch:       1000,
-		SearchQuery: dumpdata.QUERY_ALL,
-		SearchBody:  "",
+		Batch:         1000,
+		SearchQuery:   dumpdata.QUERY_ALL,
+		SearchBody:    "",
+		CheckpointSec: 30,
+		PitKeepAlive:  3600,
+		Resume:        false,
 	}
+	throttle := &throttleConfig{}
+	prog := newProgressConfig()
 	cmd := &cobra.Command{
 		Use:   "data",
 		Short: "dump data from elasticsearch",
@@ -44,7 +57,22 @@ func newCmdDumpData(_ io.Writer) *cobra.Command {
 				return err
 			}
 			if extra.OutputFile == "" {
-				extra.OutputFile = cfg.Index + "-data.json"
+				extra.OutputFile = cfg.Index + "-data.json" + helpers.CompressionExt(extra.Compress)
+			}
+			extra.Compress, err = helpers.ResolveCompression(extra.Compress, extra.OutputFile)
+			if err != nil {
+				return err
+			}
+			if extra.CheckpointFile == "" {
+				extra.CheckpointFile = extra.OutputFile + ".checkpoint"
+			}
+			if checkpointed(dopt, extra.CheckpointSec) {
+				// the point in time must outlive the checkpoint for the dump to be resumed after a crash
+				if extra.PitKeepAlive < extra.CheckpointSec {
+					return errors.Errorf("--pit-keep-alive: %ds is shorter than --checkpoint-interval: %ds", extra.PitKeepAlive, extra.CheckpointSec)
+				}
+			} else if extra.CheckpointSec > 0 && (cmd.Flags().Changed("checkpoint-interval") || cmd.Flags().Changed("checkpoint-file")) {
+				klog.Warningf("checkpoints are only written by a dump with --mode pit and without --slices, this dump can not be resumed\n")
 			}
 			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
 			return nil
@@ -55,35 +83,72 @@ func newCmdDumpData(_ io.Writer) *cobra.Command {
 			if err != nil {
 				return err
 			}
-			ops := []func(*esapi.SearchRequest){
-				client.Search.WithContext(context.Background()),
-				client.Search.WithIndex(cfg.Index),
-				client.Search.WithScroll(time.Duration(dopt.TimeoutSec) * time.Second),
-				client.Search.WithSize(extra.Batch),
-			}
-			if extra.SearchBody != "" {
-				ops = append(ops, client.Search.WithBody(strings.NewReader(extra.SearchBody)))
-			} else {
-				ops = append(ops, client.Search.WithQuery(extra.SearchQuery))
+			jobMetrics, stopMetrics, err := startMetrics(extra.MetricsAddr)
+			if err != nil {
+				return err
 			}
-			writer := dumpdata.NewLazyDataWriter(extra.OutputFile)
+			defer stopMetrics()
+			dopt.Metrics = jobMetrics.Stage("dump")
+			limiter, stopLimiter := startLimiter(throttle)
+			defer stopLimiter()
+			dopt.Limiter = limiter
+			ops := newSearchOptions(client, cfg.Index, extra.Batch, dopt, extra.SearchQuery, extra.SearchBody)
+			writer := dumpdata.NewLazyDataWriter(extra.OutputFile, extra.Compress)
 			defer func() {
 				err := writer.Close()
 				if err != nil {
 					klog.V(4).Infof("close writer failed: %v", err)
 				}
 			}()
+			if extra.Resume {
+				cp, err := dumpdata.LoadCheckpoint(extra.CheckpointFile)
+				if err != nil {
+					return err
+				}
+				if cp.File != extra.OutputFile || cp.Compress != extra.Compress {
+					return errors.Errorf("checkpoint: %s was saved for file: %s, compress: %s", extra.CheckpointFile, cp.File, cp.Compress)
+				}
+				klog.Infof("resume dump from checkpoint: %s, count: %d, offset: %d\n", extra.CheckpointFile, cp.Count, cp.Offset)
+				err = writer.Resume(cp.Offset)
+				if err != nil {
+					return err
+				}
+				dopt.Cursor = &cp.Cursor
+			}
+			if checkpointed(dopt, extra.CheckpointSec) || extra.Resume {
+				dopt.PitKeepAliveSec = extra.PitKeepAlive
+			}
+			var checkpointer *dumpdata.Checkpointer
+			if checkpointed(dopt, extra.CheckpointSec) {
+				checkpointer = dumpdata.NewCheckpointer(extra.CheckpointFile, time.Duration(extra.CheckpointSec)*time.Second, writer)
+				dopt.OnCursor = checkpointer.OnCursor
+			}
+			total := countDocs(client, cfg.Index, extra.SearchQuery, extra.SearchBody)
+			if dopt.Limit > 0 {
+				total = min(total, int64(dopt.Limit))
+			}
+			if dopt.Cursor != nil {
+				total = max(total-int64(dopt.Cursor.Count), 0)
+			}
+			var finish func(error) error
+			dopt.Progress, finish = startProgress(prog, "dump "+cfg.Index, total)
 			ncount, err := dumpdata.DumpData(client, dopt, writer.Write, ops...)
 			if err != nil {
-				return err
+				return finish(err)
 			}
 			err = writer.Close()
 			if err != nil {
-				return err
+				return finish(err)
+			}
+			if checkpointer != nil {
+				err = checkpointer.Remove()
+				if err != nil {
+					klog.V(4).Infof("remove checkpoint failed: %v", err)
+				}
 			}
 			cost := time.Since(startTime).Seconds()
 			klog.Infof("dump data succeed, total: %d, index: %s, file: %s, cost: %.3fs\n", ncount, cfg.Index, extra.OutputFile, cost)
-			return nil
+			return finish(nil)
 		},
 		Args: cobra.NoArgs,
 	}
@@ -92,13 +157,68 @@ func newCmdDumpData(_ io.Writer) *cobra.Command {
 	flagSet := cmd.Flags()
 
 	flagSet.IntVarP(&dopt.Limit, "limit", "l", dopt.Limit, "limit size when scroll")
-	flagSet.IntVar(&dopt.TimeoutSec, "timeout", dopt.TimeoutSec, "timeout (second) when scroll")
+	flagSet.IntVar(&dopt.TimeoutSec, "timeout", dopt.TimeoutSec, "timeout (second) when scroll, also the keep alive of a point in time without checkpoints")
+	flagSet.StringVar(&dopt.Mode, "mode", dopt.Mode, "how to page through the index: scroll or pit (point in time with search_after)")
+	flagSet.IntVar(&dopt.Slices, "slices", dopt.Slices, "number of sliced scrolls read concurrently")
+	flagSet.BoolVar(&dopt.Ordered, "ordered", dopt.Ordered, "write pages of slices in round-robin order instead of arrival order")
+	flagSet.BoolVar(&dopt.WithVersion, "with-version", dopt.WithVersion, "also dump _version, _seq_no and _primary_term of every document")
 
 	flagSet.StringVarP(&extra.OutputFile, "file", "f", extra.OutputFile, "output file")
+	flagSet.StringVar(&extra.Compress, "compress", extra.Compress, "compress output file: none, gzip or zstd, default by the file extension (.gz, .zst)")
+	flagSet.StringVar(&extra.CheckpointFile, "checkpoint-file", extra.CheckpointFile, "checkpoint file of a pit dump, default <file>.checkpoint")
+	flagSet.IntVar(&extra.CheckpointSec, "checkpoint-interval", extra.CheckpointSec, "interval (second) between checkpoints of a pit dump, 0 disables checkpoints")
+	flagSet.IntVar(&extra.PitKeepAlive, "pit-keep-alive", extra.PitKeepAlive, "keep alive (second) of the point in time of a checkpointed dump, a failed dump can be resumed within this time")
+	flagSet.BoolVar(&extra.Resume, "resume", extra.Resume, "resume a pit dump from its checkpoint, within --pit-keep-alive since the last checkpoint")
 
 	flagSet.IntVarP(&extra.Batch, "batch", "b", extra.Batch, "batch size when scroll")
 	flagSet.StringVarP(&extra.SearchQuery, "search_query", "q", extra.SearchQuery, "search query")
 	flagSet.StringVarP(&extra.SearchBody, "search_body", "d", extra.SearchBody, "search body")
 
+	addThrottleFlags(flagSet, throttle)
+	addProgressFlags(flagSet, prog)
+	addMetricsFlags(flagSet, &extra.MetricsAddr)
+
 	return cmd
 }
+
+// checkpointed reports whether a dump writes checkpoints, only a point in time dump without slices can be resumed
+func checkpointed(dopt *dumpdata.DumpDataOption, checkpointSec int) bool {
+	return dopt.Mode == dumpdata.ModePit && dopt.Slices <= 1 && checkpointSec > 0
+}
+
+// dumpDataFile dumps the hits of the search into outputFile and returns their count
+func dumpDataFile(client *elasticsearch.Client, dopt *dumpdata.DumpDataOption, outputFile, compression string, o ...func(*esapi.SearchRequest)) (int, error) {
+	writer := dumpdata.NewLazyDataWriter(outputFile, compression)
+	defer func() {
+		err := writer.Close()
+		if err != nil {
+			klog.V(4).Infof("close writer failed: %v", err)
+		}
+	}()
+	ncount, err := dumpdata.DumpData(client, dopt, writer.Write, o...)
+	if err != nil {
+		return ncount, err
+	}
+	return ncount, writer.Close()
+}
+
+// newSearchOptions builds the search request of a dump
+func newSearchOptions(client *elasticsearch.Client, index string, batch int, dopt *dumpdata.DumpDataOption, searchQuery, searchBody string) []func(*esapi.SearchRequest) {
+	ops := []func(*esapi.SearchRequest){
+		client.Search.WithContext(context.Background()),
+		client.Search.WithIndex(index),
+		client.Search.WithSize(batch),
+	}
+	if dopt.Mode == dumpdata.ModeScroll {
+		ops = append(ops, client.Search.WithScroll(time.Duration(dopt.TimeoutSec)*time.Second))
+	}
+	if dopt.WithVersion {
+		ops = append(ops, client.Search.WithVersion(true), client.Search.WithSeqNoPrimaryTerm(true))
+	}
+	if searchBody != "" {
+		ops = append(ops, client.Search.WithBody(strings.NewReader(searchBody)))
+	} else {
+		ops = append(ops, client.Search.WithQuery(searchQuery))
+	}
+	return ops
+}
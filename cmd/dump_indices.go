@@ -0,0 +1,134 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/dumpdata"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/manifest"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdDumpIndices(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		OutputDir   string
+		Compress    string
+		Batch       int
+		SearchQuery string
+		SearchBody  string
+		MetricsAddr string
+	}
+	cfg := newBaseConfig()
+	cfg.Index = "*"
+	dopt := dumpdata.NewDumpDataOption()
+	extra := &extraOption{
+		OutputDir:   "dump",
+		Batch:       1000,
+		SearchQuery: dumpdata.QUERY_ALL,
+		SearchBody:  "",
+	}
+	cmd := &cobra.Command{
+		Use:   "indices",
+		Short: "dump mapping and data of every index matching --index into a directory",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, opt: %s, extra: %s", helpers.ToJSON(cfg), helpers.ToJSON(dopt), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			extra.Compress, err = helpers.ResolveCompression(extra.Compress, "")
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
+			if err != nil {
+				return err
+			}
+			indices, err := helpers.ResolveIndices(client, cfg.Index)
+			if err != nil {
+				return err
+			}
+			jobMetrics, stopMetrics, err := startMetrics(extra.MetricsAddr)
+			if err != nil {
+				return err
+			}
+			defer stopMetrics()
+			dopt.Metrics = jobMetrics.Stage("dump")
+			if len(indices) == 0 {
+				return errors.Errorf("no index matches: %s", cfg.Index)
+			}
+			klog.Infof("dumping %d indices: %v\n", len(indices), indices)
+			err = os.MkdirAll(extra.OutputDir, 0755)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			m := &manifest.Manifest{CreatedAt: startTime}
+			ext := helpers.CompressionExt(extra.Compress)
+			for _, index := range indices {
+				entry := &manifest.Index{
+					Name:    index,
+					Mapping: index + "-mapping.json" + ext,
+					Data:    index + "-data.json" + ext,
+				}
+				err = dumpMapping(client, index, filepath.Join(extra.OutputDir, entry.Mapping), extra.Compress)
+				if err != nil {
+					return errors.WithMessagef(err, "index: %s", index)
+				}
+				ops := newSearchOptions(client, index, extra.Batch, dopt, extra.SearchQuery, extra.SearchBody)
+				entry.Count, err = dumpDataFile(client, dopt, filepath.Join(extra.OutputDir, entry.Data), extra.Compress, ops...)
+				if err != nil {
+					return errors.WithMessagef(err, "index: %s", index)
+				}
+				klog.Infof("dump index succeed, total: %d, index: %s\n", entry.Count, index)
+				m.Indices = append(m.Indices, entry)
+				// keep the manifest in sync so a failed dump still lists the finished indices
+				err = m.Save(extra.OutputDir)
+				if err != nil {
+					return err
+				}
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("dump indices succeed, indices: %d, dir: %s, cost: %.3fs\n", len(m.Indices), extra.OutputDir, cost)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	flagSet.Lookup("index").Usage = "elasticsearch index names and wildcard patterns, separated by comma"
+
+	flagSet.IntVarP(&dopt.Limit, "limit", "l", dopt.Limit, "limit size of each index when scroll")
+	flagSet.IntVar(&dopt.TimeoutSec, "timeout", dopt.TimeoutSec, "timeout (second) when scroll, also the keep alive of point in time")
+	flagSet.StringVar(&dopt.Mode, "mode", dopt.Mode, "how to page through the index: scroll or pit (point in time with search_after)")
+	flagSet.IntVar(&dopt.Slices, "slices", dopt.Slices, "number of sliced scrolls read concurrently")
+	flagSet.BoolVar(&dopt.Ordered, "ordered", dopt.Ordered, "write pages of slices in round-robin order instead of arrival order")
+	flagSet.BoolVar(&dopt.WithVersion, "with-version", dopt.WithVersion, "also dump _version, _seq_no and _primary_term of every document")
+
+	flagSet.StringVar(&extra.OutputDir, "dir", extra.OutputDir, "output directory")
+	flagSet.StringVar(&extra.Compress, "compress", extra.Compress, "compress output files: none, gzip or zstd")
+
+	flagSet.IntVarP(&extra.Batch, "batch", "b", extra.Batch, "batch size when scroll")
+	flagSet.StringVarP(&extra.SearchQuery, "search_query", "q", extra.SearchQuery, "search query")
+	flagSet.StringVarP(&extra.SearchBody, "search_body", "d", extra.SearchBody, "search body")
+	addMetricsFlags(flagSet, &extra.MetricsAddr)
+
+	return cmd
+}
@@ -8,12 +8,11 @@ package cmd
 
 import (
 	"io"
-	"os"
 	"time"
 
 	"github.com/shinexia/elasticdump/pkg/helpers"
 
-	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
@@ -21,7 +20,10 @@ import (
 
 func newCmdDumpMapping(_ io.Writer) *cobra.Command {
 	type extraOption struct {
-		OutputFile string
+		OutputFile  string
+		AliasesFile string
+		SkipAliases bool
+		Compress    string
 	}
 	cfg := newBaseConfig()
 	extra := &extraOption{}
@@ -35,45 +37,36 @@ func newCmdDumpMapping(_ io.Writer) *cobra.Command {
 				return err
 			}
 			if extra.OutputFile == "" {
-				extra.OutputFile = cfg.Index + "-mapping.json"
+				extra.OutputFile = cfg.Index + "-mapping.json" + helpers.CompressionExt(extra.Compress)
+			}
+			extra.Compress, err = helpers.ResolveCompression(extra.Compress, extra.OutputFile)
+			if err != nil {
+				return err
+			}
+			if extra.AliasesFile == "" {
+				extra.AliasesFile = cfg.Index + "-aliases.json" + helpers.CompressionExt(extra.Compress)
 			}
 			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			startTime := time.Now()
-			client, err := helpers.NewElasticSearchClient(helpers.PathJoin(cfg.Host, cfg.Index), cfg.InsecureSkipVerify)
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
 			if err != nil {
 				return err
 			}
-			res, err := client.Info(func(r *esapi.InfoRequest) {
-				r.Pretty = true
-			}, client.Info.WithHuman())
-			if err != nil {
-				return errors.Cause(err)
-			}
-			body, err := io.ReadAll(res.Body)
-			if res.IsError() || err != nil {
-				return errors.Errorf("status: %d, body: %s", res.StatusCode, string(body))
-			}
-			outputFile := extra.OutputFile
-			klog.V(5).Infof("writing mapping to: %s\n", outputFile)
-			writer, err := os.Create(outputFile)
+			err = dumpMapping(client, cfg.Index, extra.OutputFile, extra.Compress)
 			if err != nil {
-				return errors.Wrapf(err, "create file: %s failed", outputFile)
+				return err
 			}
-			defer func() {
-				err := writer.Close()
+			if !extra.SkipAliases {
+				err = dumpAliases(client, cfg.Index, extra.AliasesFile, extra.Compress)
 				if err != nil {
-					klog.V(4).Infof("close writer failed: %v", err)
+					return err
 				}
-			}()
-			_, err = writer.Write(body)
-			if err != nil {
-				return errors.Wrapf(err, "dest: %s", outputFile)
 			}
 			cost := time.Since(startTime).Seconds()
-			klog.Infof("dump mapping succeed, cost: %.3fs, index: %s, file: %s", cost, cfg.Index, outputFile)
+			klog.Infof("dump mapping succeed, cost: %.3fs, index: %s, file: %s", cost, cfg.Index, extra.OutputFile)
 			return nil
 		},
 		Args: cobra.NoArgs,
@@ -82,6 +75,70 @@ func newCmdDumpMapping(_ io.Writer) *cobra.Command {
 	flagSet := cmd.Flags()
 
 	flagSet.StringVarP(&extra.OutputFile, "file", "f", extra.OutputFile, "output file")
+	flagSet.StringVar(&extra.AliasesFile, "aliases-file", extra.AliasesFile, "output file of the aliases pointing at the index, default <index>-aliases.json")
+	flagSet.BoolVar(&extra.SkipAliases, "skip-aliases", extra.SkipAliases, "do not write the aliases file")
+	flagSet.StringVar(&extra.Compress, "compress", extra.Compress, "compress output file: none, gzip or zstd, default by the file extension (.gz, .zst)")
 
 	return cmd
 }
+
+// dumpMapping writes the mappings, settings and aliases of index to outputFile
+func dumpMapping(client *elasticsearch.Client, index, outputFile, compression string) error {
+	res, err := client.Indices.Get([]string{index}, client.Indices.Get.WithPretty(), client.Indices.Get.WithHuman())
+	if err != nil {
+		return errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if res.IsError() || err != nil {
+		return errors.Errorf("status: %d, body: %s", res.StatusCode, string(body))
+	}
+	klog.V(5).Infof("writing mapping to: %s\n", outputFile)
+	writer, err := helpers.CreateFile(outputFile, compression)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := writer.Close()
+		if err != nil {
+			klog.V(4).Infof("close writer failed: %v", err)
+		}
+	}()
+	_, err = writer.Write(body)
+	if err != nil {
+		return errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	err = writer.Close()
+	if err != nil {
+		return errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	return nil
+}
+
+// dumpAliases writes the aliases pointing at index with their filter, routing and is_write_index to outputFile
+func dumpAliases(client *elasticsearch.Client, index, outputFile, compression string) error {
+	res, err := client.Indices.GetAlias(client.Indices.GetAlias.WithIndex(index), client.Indices.GetAlias.WithPretty())
+	if err != nil {
+		return errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if res.IsError() || err != nil {
+		return errors.Errorf("status: %d, body: %s", res.StatusCode, string(body))
+	}
+	klog.V(5).Infof("writing aliases to: %s\n", outputFile)
+	writer, err := helpers.CreateFile(outputFile, compression)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(body)
+	if err != nil {
+		writer.Close()
+		return errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	err = writer.Close()
+	if err != nil {
+		return errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	return nil
+}
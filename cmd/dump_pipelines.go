@@ -0,0 +1,78 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/cluster"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdDumpPipelines(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		OutputFile    string
+		Compress      string
+		Name          string
+		IncludeSystem bool
+	}
+	cfg := newBaseConfig()
+	extra := &extraOption{
+		Name:          "*",
+		IncludeSystem: false,
+	}
+	cmd := &cobra.Command{
+		Use:   "pipelines",
+		Short: "dump ingest pipelines from elasticsearch",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, extra: %v", helpers.ToJSON(cfg), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			if extra.OutputFile == "" {
+				extra.OutputFile = "pipelines.json" + helpers.CompressionExt(extra.Compress)
+			}
+			extra.Compress, err = helpers.ResolveCompression(extra.Compress, extra.OutputFile)
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
+			if err != nil {
+				return err
+			}
+			kinds := []cluster.Kind{cluster.KindPipeline}
+			count, err := dumpObjects(client, kinds, extra.Name, extra.IncludeSystem, extra.OutputFile, extra.Compress)
+			if err != nil {
+				return err
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("dump pipelines succeed, total: %d, cost: %.3fs, file: %s", count, cost, extra.OutputFile)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	_ = flagSet.MarkHidden("index")
+
+	flagSet.StringVarP(&extra.OutputFile, "file", "f", extra.OutputFile, "output file, default pipelines.json")
+	flagSet.StringVar(&extra.Compress, "compress", extra.Compress, "compress output file: none, gzip or zstd, default by the file extension (.gz, .zst)")
+	flagSet.StringVar(&extra.Name, "name", extra.Name, "only dump pipelines matching these names or wildcard patterns, separated by comma")
+	flagSet.BoolVar(&extra.IncludeSystem, "include-system", extra.IncludeSystem, "also dump dot-prefixed and managed pipelines of elasticsearch itself")
+
+	return cmd
+}
@@ -62,7 +62,10 @@ func newCmdTestGenData(_ io.Writer) *cobra.Command {
 					rerr = err
 				}
 			}()
-			err = loaddata.LoadData(client, queue, extra.Batch, cfg.Index)
+			lopt := loaddata.NewLoadDataOption()
+			lopt.Index = cfg.Index
+			lopt.Batch = extra.Batch
+			err = loaddata.LoadData(client, queue, lopt)
 			if err != nil {
 				return err
 			}
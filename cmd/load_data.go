@@ -8,11 +8,12 @@ package cmd
 
 import (
 	"io"
-	"os"
+	"time"
 
 	"github.com/shinexia/elasticdump/pkg/helpers"
 	"github.com/shinexia/elasticdump/pkg/loaddata"
 
+	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
@@ -20,85 +21,197 @@ import (
 
 func newCmdLoadData(_ io.Writer) *cobra.Command {
 	type extraOption struct {
-		InputFile string
-		Batch     int
-		Limit     int
-		BufSize   int
-		Delete    bool
+		InputFile      string
+		FailedFile     string
+		Limit          int
+		BufSize        int
+		QueueSize      int
+		QueueBytes     int64
+		Delete         bool
+		CheckpointFile string
+		CheckpointSec  int
+		Resume         bool
+		MetricsAddr    string
 	}
 	cfg := newBaseConfig()
+	lopt := loaddata.NewLoadDataOption()
 	extra := &extraOption{
-		InputFile: "",
-		Batch:     1000,
-		Limit:     -1,
-		BufSize:   1024 * 1024 * 1024,
-		Delete:    false,
+		InputFile:     "",
+		Limit:         -1,
+		BufSize:       1024 * 1024 * 1024,
+		QueueSize:     0,
+		QueueBytes:    256 * 1024 * 1024,
+		Delete:        false,
+		CheckpointSec: 30,
+		Resume:        false,
 	}
+	throttle := &throttleConfig{}
+	prog := newProgressConfig()
 	cmd := &cobra.Command{
 		Use:   "data",
 		Short: "load data to elasticsearch",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
-			klog.V(5).Infof("cmd: %v\n", helpers.ToJSON(cfg))
+			klog.V(5).Infof("cfg: %v, opt: %s, extra: %s", helpers.ToJSON(cfg), helpers.ToJSON(lopt), helpers.ToJSON(extra))
 			err = preprocessBaseConfig(cfg)
 			if err != nil {
 				return err
 			}
 			if extra.InputFile == "" {
-				extra.InputFile = cfg.Index + "-data.json"
+				extra.InputFile = helpers.FindCompressedFile(cfg.Index + "-data.json")
+			}
+			if extra.CheckpointFile == "" {
+				extra.CheckpointFile = extra.InputFile + ".load-checkpoint"
+			}
+			if extra.Resume && extra.Delete {
+				return errors.New("--resume can not be used with --delete")
 			}
 			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify, helpers.WithoutTransportRetry())
 			if err != nil {
 				return err
 			}
+			jobMetrics, stopMetrics, err := startMetrics(extra.MetricsAddr)
+			if err != nil {
+				return err
+			}
+			defer stopMetrics()
+			lopt.Metrics = jobMetrics.Stage("load")
+			limiter, stopLimiter := startLimiter(throttle)
+			defer stopLimiter()
+			lopt.Limiter = limiter
 			if extra.Delete {
 				err = deleteIndexIfExists(client, cfg.Index)
 				if err != nil {
 					return err
 				}
 			}
-			inputFile := extra.InputFile
-			klog.V(5).Infof("load data to index: %s, from: %s, batch: %v, limit: %v, bufSize: %v\n", cfg.Index, inputFile, extra.Batch, extra.Limit, extra.BufSize)
-			queue := loaddata.NewDataQueue[*loaddata.Hit]()
-			var rerr error
-			//  async read records from file
-			go func() {
-				defer queue.Stop()
-				file, err := os.Open(inputFile)
-				if err != nil {
-					rerr = errors.WithMessagef(err, "file: %s", inputFile)
-					return
-				}
+			lopt.Index = cfg.Index
+			if extra.FailedFile != "" {
+				failedWriter := loaddata.NewLazyFailedWriter(extra.FailedFile)
 				defer func() {
-					err := file.Close()
+					err := failedWriter.Close()
 					if err != nil {
-						klog.V(4).Infof("close writer failed: %v", err)
+						klog.V(4).Infof("close failed writer failed: %v", err)
 					}
 				}()
-				err = loaddata.LoadHits(queue, file, extra.BufSize)
+				lopt.OnFailed = failedWriter.Write
+			}
+			inputFile := extra.InputFile
+			skipLines := int64(0)
+			if extra.Resume {
+				cp, err := loaddata.LoadCheckpoint(extra.CheckpointFile)
 				if err != nil {
-					rerr = err
+					return err
+				}
+				if cp.File != inputFile {
+					return errors.Errorf("checkpoint: %s was saved for file: %s", extra.CheckpointFile, cp.File)
+				}
+				klog.Infof("resume load from checkpoint: %s, line: %d\n", extra.CheckpointFile, cp.Line)
+				skipLines = cp.Line
+			}
+			var checkpointer *loaddata.Checkpointer
+			if extra.CheckpointSec > 0 {
+				checkpointer = loaddata.NewCheckpointer(extra.CheckpointFile, inputFile, time.Duration(extra.CheckpointSec)*time.Second)
+				lopt.OnAck = checkpointer.OnAck
+			}
+			klog.V(5).Infof("load data to index: %s, from: %s, batch: %v, workers: %v, limit: %v, bufSize: %v\n", cfg.Index, inputFile, lopt.Batch, lopt.Workers, extra.Limit, extra.BufSize)
+			queue := loaddata.NewBoundedDataQueue(extra.QueueSize, extra.QueueBytes, loaddata.HitSize)
+			jobMetrics.SetQueueDepth(queue.Len)
+			var finish func(error) error
+			lopt.Progress, finish = startProgress(prog, "load "+cfg.Index, 0)
+			err = loadDataFile(client, queue, inputFile, extra.BufSize, skipLines, lopt)
+			if checkpointer != nil {
+				if err != nil {
+					cerr := checkpointer.Save()
+					if cerr != nil {
+						klog.Infof("save checkpoint failed: %v", cerr)
+					}
+				} else {
+					cerr := checkpointer.Remove()
+					if cerr != nil {
+						klog.V(4).Infof("remove checkpoint failed: %v", cerr)
+					}
 				}
-			}()
-			err = loaddata.LoadData(client, queue, extra.Batch, cfg.Index)
-			if err != nil {
-				return err
 			}
-			return rerr
+			return finish(err)
 		},
 		Args: cobra.NoArgs,
 	}
 	addBaseConfigFlags(cmd.Flags(), cfg)
 	flagSet := cmd.Flags()
 
-	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input file")
+	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input file, default <index>-data.json or the .gz or .zst of it written by a compressed dump, gzip and zstd compressed files are detected automatically")
+	flagSet.StringVar(&extra.FailedFile, "failed-file", extra.FailedFile, "write documents failed to index to this file, it can be loaded again after fixing")
 
-	flagSet.IntVarP(&extra.Batch, "batch", "b", extra.Batch, "batch size when scroll")
+	flagSet.StringVar(&lopt.Action, "action", lopt.Action, "bulk action: index (overwrite), create, update, upsert (update with doc_as_upsert) or delete (by _id)")
+	flagSet.IntVarP(&lopt.Batch, "batch", "b", lopt.Batch, "max documents per bulk request, also capped by --batch-bytes and adapted by --adaptive")
+	flagSet.Int64Var(&lopt.BatchBytes, "batch-bytes", lopt.BatchBytes, "max bytes of one bulk request, a request rejected with 413 is split, 0 is unlimited")
+	flagSet.BoolVar(&lopt.Adaptive, "adaptive", lopt.Adaptive, "adapt the batch size starting from --batch: grow it while bulks are faster than --target-latency, shrink it on slow or rejected bulks")
+	flagSet.IntVar(&lopt.MinBatch, "min-batch", lopt.MinBatch, "min batch size with --adaptive")
+	flagSet.IntVar(&lopt.MaxBatch, "max-batch", lopt.MaxBatch, "max batch size with --adaptive")
+	flagSet.IntVar(&lopt.TargetLatencyMs, "target-latency", lopt.TargetLatencyMs, "target latency (millisecond) of a bulk request with --adaptive")
+	flagSet.IntVar(&lopt.Workers, "workers", lopt.Workers, "number of concurrent bulk requests")
+	flagSet.IntVar(&lopt.MaxRetries, "max-retries", lopt.MaxRetries, "max retries of documents and bulk requests rejected with 429/5xx")
+	flagSet.IntVar(&lopt.RetryBackoffMs, "retry-backoff", lopt.RetryBackoffMs, "initial backoff (millisecond) before a retry, doubled on each attempt")
+	flagSet.IntVar(&lopt.MaxRetryBackoffMs, "max-retry-backoff", lopt.MaxRetryBackoffMs, "max backoff (millisecond) before a retry")
+	flagSet.IntVar(&lopt.BulkTimeoutSec, "bulk-timeout", lopt.BulkTimeoutSec, "timeout (second) of a bulk request, a timed out request is retried with a smaller batch, 0 waits forever")
+	flagSet.StringVar(&lopt.Pipeline, "pipeline", lopt.Pipeline, "ingest pipeline to preprocess documents with")
+	flagSet.IntVar(&lopt.Version, "target-version", lopt.Version, "major elasticsearch version of the target, before 7 bulk metadata has a _type, 0 detects it from the cluster and assumes the latest version when the info api fails")
+	flagSet.StringVar(&lopt.DocType, "doc-type", lopt.DocType, "_type of documents without one when the target is before 7")
+	flagSet.StringVar(&lopt.VersionType, "version-type", lopt.VersionType, "external or external_gte: index documents with their dumped _version (see --with-version) and keep newer ones, requires --action index or delete")
+	flagSet.BoolVar(&lopt.KeepIndex, "keep-index", lopt.KeepIndex, "load every document into the index it was dumped from instead of --index")
 	flagSet.IntVarP(&extra.Limit, "limit", "l", extra.Limit, "limit size when scroll")
 	flagSet.IntVar(&extra.BufSize, "buf", extra.BufSize, "buffer size (byte) when split data file to lines, must bigger than the largest line")
+	flagSet.IntVar(&extra.QueueSize, "queue-size", extra.QueueSize, "max number of documents buffered between file reader and bulk workers, 0 is unlimited")
+	flagSet.Int64Var(&extra.QueueBytes, "queue-bytes", extra.QueueBytes, "max bytes of documents buffered between file reader and bulk workers, 0 is unlimited; the default changed from unlimited to 256MiB, the reader now waits for the bulk workers")
 	flagSet.BoolVar(&extra.Delete, "delete", extra.Delete, "whether delete the index before load")
+	flagSet.StringVar(&extra.CheckpointFile, "checkpoint-file", extra.CheckpointFile, "checkpoint file of the load, default <file>.load-checkpoint")
+	flagSet.IntVar(&extra.CheckpointSec, "checkpoint-interval", extra.CheckpointSec, "interval (second) between checkpoints, 0 disables checkpoints")
+	flagSet.BoolVar(&extra.Resume, "resume", extra.Resume, "skip the lines acknowledged before according to the checkpoint")
+	addThrottleFlags(flagSet, throttle)
+	addProgressFlags(flagSet, prog)
+	addMetricsFlags(flagSet, &extra.MetricsAddr)
 	return cmd
 }
+
+// loadDataFile loads the hits of inputFile, skipping the first skipLines lines, through queue into lopt.Index,
+// the progress is estimated by the part of the file read
+func loadDataFile(client *elasticsearch.Client, queue *loaddata.DataQueue[*loaddata.Hit], inputFile string, bufSize int, skipLines int64, lopt *loaddata.LoadDataOption) error {
+	file, err := helpers.OpenTrackedFile(inputFile, lopt.Progress.TrackReader)
+	if err != nil {
+		queue.Stop()
+		return err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			klog.V(4).Infof("close reader failed: %v", err)
+		}
+	}()
+	return loadDataReader(client, queue, file, bufSize, skipLines, lopt)
+}
+
+// loadDataReader loads the hits read from in, skipping the first skipLines lines, through queue into lopt.Index
+func loadDataReader(client *elasticsearch.Client, queue *loaddata.DataQueue[*loaddata.Hit], in io.Reader, bufSize int, skipLines int64, lopt *loaddata.LoadDataOption) error {
+	var rerr error
+	done := make(chan struct{})
+	//  async read records from file
+	go func() {
+		defer close(done)
+		defer queue.Stop()
+		err := loaddata.LoadHitsFrom(queue, in, bufSize, skipLines)
+		if err != nil {
+			rerr = err
+		}
+	}()
+	err := loaddata.LoadData(client, queue, lopt)
+	// a failed load stops the queue, which ends the reader
+	<-done
+	if err != nil {
+		return err
+	}
+	return rerr
+}
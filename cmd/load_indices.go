@@ -0,0 +1,154 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/loaddata"
+	"github.com/shinexia/elasticdump/pkg/manifest"
+	"github.com/shinexia/elasticdump/pkg/mapping"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdLoadIndices(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		InputDir    string
+		BufSize     int
+		QueueSize   int
+		QueueBytes  int64
+		Delete      bool
+		SkipMapping bool
+		MetricsAddr string
+	}
+	cfg := newBaseConfig()
+	cfg.Index = "*"
+	lopt := loaddata.NewLoadDataOption()
+	extra := &extraOption{
+		InputDir:    "dump",
+		BufSize:     1024 * 1024 * 1024,
+		QueueSize:   0,
+		QueueBytes:  256 * 1024 * 1024,
+		Delete:      false,
+		SkipMapping: false,
+	}
+	cmd := &cobra.Command{
+		Use:   "indices",
+		Short: "load mapping and data of every index in a directory written by dump indices",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, opt: %s, extra: %s", helpers.ToJSON(cfg), helpers.ToJSON(lopt), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify, helpers.WithoutTransportRetry())
+			if err != nil {
+				return err
+			}
+			m, err := manifest.Load(extra.InputDir)
+			if err != nil {
+				return err
+			}
+			target := targetVersion(client, lopt.Version)
+			lopt.Version = target
+			jobMetrics, stopMetrics, err := startMetrics(extra.MetricsAddr)
+			if err != nil {
+				return err
+			}
+			defer stopMetrics()
+			lopt.Metrics = jobMetrics.Stage("load")
+			count := 0
+			for _, entry := range m.Indices {
+				ok, err := matchIndex(cfg.Index, entry.Name)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					klog.V(4).Infof("skip index: %s\n", entry.Name)
+					continue
+				}
+				if extra.Delete {
+					err = deleteIndexIfExists(client, entry.Name)
+					if err != nil {
+						return err
+					}
+				}
+				if !extra.SkipMapping {
+					_, err = loadMapping(client, entry.Name, filepath.Join(extra.InputDir, entry.Mapping), mapping.AliasRecreate, "", target)
+					if err != nil {
+						return errors.WithMessagef(err, "index: %s", entry.Name)
+					}
+				}
+				iopt := *lopt
+				iopt.Index = entry.Name
+				queue := loaddata.NewBoundedDataQueue(extra.QueueSize, extra.QueueBytes, loaddata.HitSize)
+				jobMetrics.SetQueueDepth(queue.Len)
+				err = loadDataFile(client, queue, filepath.Join(extra.InputDir, entry.Data), extra.BufSize, 0, &iopt)
+				if err != nil {
+					return errors.WithMessagef(err, "index: %s", entry.Name)
+				}
+				count++
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("load indices succeed, indices: %d, dir: %s, cost: %.3fs\n", count, extra.InputDir, cost)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	flagSet.Lookup("index").Usage = "only load indexes matching these names or wildcard patterns, separated by comma"
+
+	flagSet.StringVar(&extra.InputDir, "dir", extra.InputDir, "input directory")
+
+	flagSet.StringVar(&lopt.Action, "action", lopt.Action, "bulk action: index (overwrite), create, update, upsert (update with doc_as_upsert) or delete (by _id)")
+	flagSet.IntVarP(&lopt.Batch, "batch", "b", lopt.Batch, "max documents per bulk request, also capped by --batch-bytes")
+	flagSet.Int64Var(&lopt.BatchBytes, "batch-bytes", lopt.BatchBytes, "max bytes of one bulk request, a request rejected with 413 is split, 0 is unlimited")
+	flagSet.IntVar(&lopt.Version, "target-version", lopt.Version, "major elasticsearch version of the target, mappings are migrated for it and before 7 bulk metadata has a _type, 0 detects it from the cluster and assumes the latest version when the info api fails")
+	flagSet.IntVar(&lopt.Workers, "workers", lopt.Workers, "number of concurrent bulk requests")
+	flagSet.IntVar(&lopt.MaxRetries, "max-retries", lopt.MaxRetries, "max retries of documents and bulk requests rejected with 429/5xx")
+	flagSet.IntVar(&lopt.RetryBackoffMs, "retry-backoff", lopt.RetryBackoffMs, "initial backoff (millisecond) before a retry, doubled on each attempt")
+	flagSet.IntVar(&lopt.MaxRetryBackoffMs, "max-retry-backoff", lopt.MaxRetryBackoffMs, "max backoff (millisecond) before a retry")
+	flagSet.IntVar(&extra.BufSize, "buf", extra.BufSize, "buffer size (byte) when split data file to lines, must bigger than the largest line")
+	flagSet.IntVar(&extra.QueueSize, "queue-size", extra.QueueSize, "max number of documents buffered between file reader and bulk workers, 0 is unlimited")
+	flagSet.Int64Var(&extra.QueueBytes, "queue-bytes", extra.QueueBytes, "max bytes of documents buffered between file reader and bulk workers, 0 is unlimited")
+	flagSet.BoolVar(&extra.Delete, "delete", extra.Delete, "whether delete the indexes before load")
+	flagSet.BoolVar(&extra.SkipMapping, "skip-mapping", extra.SkipMapping, "only load data into existing indexes")
+	addMetricsFlags(flagSet, &extra.MetricsAddr)
+	return cmd
+}
+
+// matchIndex reports whether index matches any of the comma separated names or wildcard patterns
+func matchIndex(patterns, index string) (bool, error) {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		ok, err := path.Match(pattern, index)
+		if err != nil {
+			return false, errors.Wrapf(err, "index pattern: %s", pattern)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -8,27 +8,39 @@ package cmd
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/shinexia/elasticdump/pkg/helpers"
 	"github.com/shinexia/elasticdump/pkg/mapping"
 
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
 )
 
-func newCmdLoadMapping(_ io.Writer) *cobra.Command {
+func newCmdLoadMapping(out io.Writer) *cobra.Command {
 	type extraOption struct {
-		InputFile string
-		Delete    bool `json:"delete"`
+		InputFile     string
+		Delete        bool `json:"delete"`
+		Aliases       string
+		AliasesFile   string
+		TargetVersion int
+		DryRun        bool
 	}
 	cfg := newBaseConfig()
 	extra := &extraOption{
-		InputFile: "",
-		Delete:    false,
+		InputFile:     "",
+		Delete:        false,
+		Aliases:       mapping.AliasRecreate,
+		AliasesFile:   "",
+		TargetVersion: 0,
+		DryRun:        false,
 	}
 	cmd := &cobra.Command{
 		Use:   "mapping",
@@ -40,7 +52,11 @@ func newCmdLoadMapping(_ io.Writer) *cobra.Command {
 				return err
 			}
 			if extra.InputFile == "" {
-				extra.InputFile = cfg.Index + "-mapping.json"
+				extra.InputFile = helpers.FindCompressedFile(cfg.Index + "-mapping.json")
+			}
+			err = mapping.ValidateAliasMode(extra.Aliases)
+			if err != nil {
+				return err
 			}
 			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
 			return nil
@@ -50,6 +66,10 @@ func newCmdLoadMapping(_ io.Writer) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			target := targetVersion(client, extra.TargetVersion)
+			if extra.DryRun {
+				return printMigratedMapping(out, extra.InputFile, target)
+			}
 			if extra.Delete {
 				err = deleteIndexIfExists(client, cfg.Index)
 				if err != nil {
@@ -57,23 +77,11 @@ func newCmdLoadMapping(_ io.Writer) *cobra.Command {
 				}
 			}
 			inputFile := extra.InputFile
-			klog.V(5).Infof("reading file: %s\n", inputFile)
 			startTime := time.Now()
-			mappingData, err := os.ReadFile(inputFile)
-			if err != nil {
-				return errors.Wrapf(err, "read file: %s failed", inputFile)
-			}
-			reqData, err := mapping.CleanUpMapping(string(mappingData))
+			res, err := loadMapping(client, cfg.Index, inputFile, extra.Aliases, extra.AliasesFile, target)
 			if err != nil {
 				return err
 			}
-			res, err := client.Indices.Create(cfg.Index, client.Indices.Create.WithBody(bytes.NewReader([]byte(reqData))))
-			if err != nil {
-				return err
-			}
-			if res.IsError() {
-				return errors.New(res.String())
-			}
 			cost := time.Since(startTime).Seconds()
 			klog.Infof("load mapping succeed, cost: %.3fs, index: %s, file: %s, message: %s\n", cost, cfg.Index, inputFile, res)
 			return nil
@@ -83,8 +91,185 @@ func newCmdLoadMapping(_ io.Writer) *cobra.Command {
 	addBaseConfigFlags(cmd.Flags(), cfg)
 	flagSet := cmd.Flags()
 
-	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input file")
+	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input file, default <index>-mapping.json or the .gz or .zst of it written by a compressed dump, gzip and zstd compressed files are detected automatically")
 	flagSet.BoolVar(&extra.Delete, "delete", extra.Delete, "whether delete the index before load")
+	flagSet.StringVar(&extra.Aliases, "aliases", extra.Aliases, "recreate: add the dumped aliases to the index, remap: move the aliases from the indexes holding them to the index, skip: ignore aliases")
+	flagSet.StringVar(&extra.AliasesFile, "aliases-file", extra.AliasesFile, "aliases written by dump mapping, default the <index>-aliases.json next to the mapping file when it exists, else the aliases in the mapping file")
+	flagSet.IntVar(&extra.TargetVersion, "target-version", extra.TargetVersion, "major elasticsearch version the mapping is migrated to, 0 detects it from the cluster and assumes the latest version when the info api fails")
+	flagSet.BoolVar(&extra.DryRun, "dry-run", extra.DryRun, "print the migrated mapping and its diff instead of creating the index")
 
 	return cmd
 }
+
+// loadMapping creates index with the mappings and settings read from inputFile,
+// migrated for the major version target, then puts the aliases read from aliasesFile as aliasMode,
+// when empty from the aliases file dump mapping wrote next to inputFile, or inputFile itself
+func loadMapping(client *elasticsearch.Client, index, inputFile, aliasMode, aliasesFile string, target int) (*esapi.Response, error) {
+	klog.V(5).Infof("reading file: %s\n", inputFile)
+	mappingData, err := helpers.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if aliasesFile == "" {
+		aliasesFile = dumpedAliasesFile(inputFile)
+	}
+	aliasData := mappingData
+	if aliasesFile != "" && aliasMode != mapping.AliasSkip {
+		klog.V(5).Infof("reading file: %s\n", aliasesFile)
+		aliasData, err = helpers.ReadFile(aliasesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return createIndex(client, index, mappingData, aliasMode, aliasData, target)
+}
+
+// dumpedAliasesFile returns the <index>-aliases.json dump mapping writes next to the <index>-mapping.json mappingFile,
+// with the same compression, or empty when there is no such file
+func dumpedAliasesFile(mappingFile string) string {
+	for _, ext := range []string{helpers.CompressionExt(helpers.CompressGzip), helpers.CompressionExt(helpers.CompressZstd), ""} {
+		name := strings.TrimSuffix(mappingFile, ext)
+		if name == mappingFile && ext != "" {
+			continue
+		}
+		if !strings.HasSuffix(name, "-mapping.json") {
+			return ""
+		}
+		aliasesFile := strings.TrimSuffix(name, "-mapping.json") + "-aliases.json" + ext
+		if _, err := os.Stat(aliasesFile); err != nil {
+			return ""
+		}
+		klog.V(4).Infof("found aliases file: %s\n", aliasesFile)
+		return aliasesFile
+	}
+	return ""
+}
+
+// createIndex creates index with mappingData as written by dump mapping and migrated for the major version target,
+// then puts the aliases of aliasData, when they can not be put the new index is deleted again
+// so a conflicting alias does not leave the index half restored
+func createIndex(client *elasticsearch.Client, index string, mappingData []byte, aliasMode string, aliasData []byte, target int) (*esapi.Response, error) {
+	reqData, changes, err := migrateMapping(mappingData, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		klog.Infof("migrate mapping of index: %s, %s\n", index, change)
+	}
+	res, err := client.Indices.Create(index, client.Indices.Create.WithBody(bytes.NewReader([]byte(reqData))))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.New(res.String())
+	}
+	err = putAliases(client, index, aliasMode, aliasData)
+	if err != nil {
+		deleteCreatedIndex(client, index)
+		return nil, err
+	}
+	return res, nil
+}
+
+// deleteCreatedIndex deletes index created by createIndex, a failure is only logged
+func deleteCreatedIndex(client *elasticsearch.Client, index string) {
+	res, err := client.Indices.Delete([]string{index})
+	if err != nil {
+		klog.Warningf("delete index: %s after its aliases failed, err: %v\n", index, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		klog.Warningf("delete index: %s after its aliases failed, res: %s\n", index, res.String())
+		return
+	}
+	klog.Infof("deleted index: %s as its aliases failed\n", index)
+}
+
+// putAliases points the aliases of aliasData at index as aliasMode
+func putAliases(client *elasticsearch.Client, index, aliasMode string, aliasData []byte) error {
+	if aliasMode == mapping.AliasSkip {
+		return nil
+	}
+	aliases, err := mapping.ParseAliases(aliasData)
+	if err != nil {
+		return err
+	}
+	body, err := mapping.AliasActions(index, aliases, aliasMode)
+	if err != nil || body == nil {
+		return err
+	}
+	klog.V(5).Infof("update aliases: %s\n", string(body))
+	res, err := client.Indices.UpdateAliases(bytes.NewReader(body))
+	if err != nil {
+		return errors.Cause(err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return errors.Errorf("update aliases of index: %s failed: %s", index, res.String())
+	}
+	klog.Infof("%s aliases of index: %s, count: %d\n", aliasMode, index, len(aliases))
+	return nil
+}
+
+// targetVersion returns version, or the major version of the cluster when version is 0,
+// mapping.LatestVersion when it can not be detected
+func targetVersion(client *elasticsearch.Client, version int) int {
+	if version > 0 {
+		return version
+	}
+	v, err := helpers.GetClusterVersion(client)
+	if err != nil {
+		klog.Warningf("detect cluster version failed: %v, migrate for version: %d, set --target-version to override\n", err, mapping.LatestVersion)
+		return mapping.LatestVersion
+	}
+	klog.V(4).Infof("cluster version: %s, distribution: %s\n", v.Number, v.Distribution)
+	return v.Major
+}
+
+// migrateMapping cleans up mappingData as written by dump mapping and, unless it was dumped from
+// the same or a newer version, rewrites it for the major version target
+func migrateMapping(mappingData []byte, target int) (string, []string, error) {
+	body, err := mapping.CleanUpMapping(string(mappingData))
+	if err != nil {
+		return "", nil, err
+	}
+	source := mapping.SourceVersion(string(mappingData))
+	if source > 0 && source >= target {
+		return body, nil, nil
+	}
+	klog.V(4).Infof("migrate mapping from version: %d to: %d\n", source, target)
+	return mapping.MigrateMapping(body, target)
+}
+
+// printMigratedMapping writes the changes, the diff and the migrated mapping of inputFile to out
+func printMigratedMapping(out io.Writer, inputFile string, target int) error {
+	mappingData, err := helpers.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	body, err := mapping.CleanUpMapping(string(mappingData))
+	if err != nil {
+		return err
+	}
+	migrated, changes, err := migrateMapping(mappingData, target)
+	if err != nil {
+		return err
+	}
+	source := mapping.SourceVersion(string(mappingData))
+	fmt.Fprintf(out, "# source version: %d, target version: %d, changes: %d\n", source, target, len(changes))
+	for _, change := range changes {
+		fmt.Fprintf(out, "# %s\n", change)
+	}
+	before, err := mapping.Normalize(body)
+	if err != nil {
+		return err
+	}
+	after, err := mapping.Normalize(migrated)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, helpers.Diff(inputFile, "migrated", before, after))
+	fmt.Fprintln(out, migrated)
+	return nil
+}
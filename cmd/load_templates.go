@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/cluster"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdLoadTemplates(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		InputFile string
+		Name      string
+		Kinds     string
+		IfExists  string
+	}
+	cfg := newBaseConfig()
+	extra := &extraOption{
+		InputFile: "templates.json",
+		Name:      "*",
+		Kinds:     templateKinds,
+		IfExists:  cluster.IfExistsOverwrite,
+	}
+	var kinds []cluster.Kind
+	cmd := &cobra.Command{
+		Use:   "templates",
+		Short: "load index templates, component templates and legacy templates to elasticsearch",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, extra: %v", helpers.ToJSON(cfg), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			kinds, err = cluster.ParseKinds(extra.Kinds)
+			if err != nil {
+				return err
+			}
+			err = cluster.ValidateIfExists(extra.IfExists)
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify)
+			if err != nil {
+				return err
+			}
+			count, err := loadObjects(client, extra.InputFile, kinds, extra.Name, extra.IfExists)
+			if err != nil {
+				return err
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("load templates succeed, total: %d, cost: %.3fs, file: %s", count, cost, extra.InputFile)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	_ = flagSet.MarkHidden("index")
+
+	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input file, gzip and zstd compressed files are detected automatically")
+	flagSet.StringVar(&extra.Name, "name", extra.Name, "only load templates matching these names or wildcard patterns, separated by comma")
+	flagSet.StringVar(&extra.Kinds, "kinds", extra.Kinds, "kinds of templates to load, separated by comma")
+	flagSet.StringVar(&extra.IfExists, "if-exists", extra.IfExists, "what to do with a template which already exists: overwrite or skip")
+
+	return cmd
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"github.com/shinexia/elasticdump/pkg/metrics"
+
+	flag "github.com/spf13/pflag"
+)
+
+func addMetricsFlags(flagSet *flag.FlagSet, addr *string) {
+	flagSet.StringVar(addr, "metrics-addr", *addr, "serve prometheus metrics of the job on http://<addr>/metrics, such as :9108, empty disables it")
+}
+
+// startMetrics serves the metrics of a command on addr until stop is called, the metrics are nil when addr is empty
+func startMetrics(addr string) (m *metrics.Metrics, stop func(), err error) {
+	if addr == "" {
+		return nil, func() {}, nil
+	}
+	m = metrics.New()
+	stop, err = metrics.Serve(addr, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, stop, nil
+}
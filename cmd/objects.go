@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/shinexia/elasticdump/pkg/cluster"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// dumpObjects writes the objects of kinds whose name matches names to outputFile,
+// objects of elasticsearch itself are skipped unless includeSystem
+func dumpObjects(client *elasticsearch.Client, kinds []cluster.Kind, names string, includeSystem bool, outputFile, compression string) (int, error) {
+	dump := cluster.Dump{}
+	count := 0
+	for _, kind := range kinds {
+		objects, err := cluster.GetObjects(client, kind, "")
+		if err != nil {
+			return 0, err
+		}
+		matched := map[string]json.RawMessage{}
+		for name, body := range objects {
+			if !includeSystem && (strings.HasPrefix(name, ".") || cluster.IsManaged(body)) {
+				continue
+			}
+			ok, err := matchIndex(names, name)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				matched[name] = body
+			}
+		}
+		klog.Infof("dump %d %s\n", len(matched), kind)
+		dump[kind] = matched
+		count += len(matched)
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	writer, err := helpers.CreateFile(outputFile, compression)
+	if err != nil {
+		return 0, err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return 0, errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "dest: %s", outputFile)
+	}
+	return count, nil
+}
+
+// loadObjects puts the objects of kinds whose name matches names from inputFile, in dependency order,
+// an existing object is replaced or kept according to ifExists
+func loadObjects(client *elasticsearch.Client, inputFile string, kinds []cluster.Kind, names string, ifExists string) (int, error) {
+	data, err := helpers.ReadFile(inputFile)
+	if err != nil {
+		return 0, err
+	}
+	dump := cluster.Dump{}
+	err = json.Unmarshal(data, &dump)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse file: %s failed", inputFile)
+	}
+	count := 0
+	for _, kind := range kinds {
+		objects := dump[kind]
+		sorted := make([]string, 0, len(objects))
+		for name := range objects {
+			ok, err := matchIndex(names, name)
+			if err != nil {
+				return count, err
+			}
+			if ok {
+				sorted = append(sorted, name)
+			}
+		}
+		sort.Strings(sorted)
+		for _, name := range sorted {
+			ok, err := putObject(client, kind, name, objects[name], ifExists)
+			if err != nil {
+				return count, err
+			}
+			if ok {
+				klog.V(4).Infof("load %s: %s\n", kind, name)
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// putObject puts the object of kind, an existing one is replaced or kept according to ifExists,
+// reports whether it was put
+func putObject(client *elasticsearch.Client, kind cluster.Kind, name string, body []byte, ifExists string) (bool, error) {
+	ok, err := cluster.PutObject(client, kind, name, body, ifExists == cluster.IfExistsSkip)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		klog.Infof("skip existing %s: %s\n", kind, name)
+	}
+	return ok, nil
+}
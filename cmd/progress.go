@@ -0,0 +1,103 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/progress"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+	flag "github.com/spf13/pflag"
+	"k8s.io/klog"
+)
+
+// progressConfig progress reporting of a dump, load or copy command
+type progressConfig struct {
+	IntervalSec int    `json:"interval_sec"`
+	StatsFile   string `json:"stats_file"`
+}
+
+func newProgressConfig() *progressConfig {
+	return &progressConfig{
+		IntervalSec: 10,
+	}
+}
+
+func addProgressFlags(flagSet *flag.FlagSet, cfg *progressConfig) {
+	flagSet.IntVar(&cfg.IntervalSec, "progress-interval", cfg.IntervalSec, "interval (second) between progress lines with rate and eta, 0 disables them")
+	flagSet.StringVar(&cfg.StatsFile, "stats-file", cfg.StatsFile, "write the final counters (docs, bytes, failures, duration) of the job as json to this file")
+}
+
+// startProgress reports the progress of job every interval until finish is called with the result of the job,
+// finish writes the stats file and returns err
+func startProgress(cfg *progressConfig, job string, total int64) (p *progress.Progress, finish func(err error) error) {
+	p = progress.New(job, total)
+	stop := p.Report(time.Duration(cfg.IntervalSec) * time.Second)
+	return p, func(err error) error {
+		stop()
+		if cfg.StatsFile != "" {
+			serr := p.WriteStatsFile(cfg.StatsFile, err)
+			if serr != nil {
+				if err != nil {
+					klog.Warningf("write stats file: %s failed: %v\n", cfg.StatsFile, serr)
+					return err
+				}
+				return serr
+			}
+		}
+		return err
+	}
+}
+
+// countDocs returns the number of documents matched by the query of a dump, 0 if it can not be counted
+func countDocs(client *elasticsearch.Client, index, searchQuery, searchBody string) int64 {
+	ops := []func(*esapi.CountRequest){
+		client.Count.WithIndex(index),
+	}
+	if searchBody != "" {
+		// _count only accepts the query of a search body
+		var body struct {
+			Query json.RawMessage `json:"query"`
+		}
+		err := json.Unmarshal([]byte(searchBody), &body)
+		if err != nil {
+			klog.V(4).Infof("count documents failed: %v", err)
+			return 0
+		}
+		if len(body.Query) > 0 {
+			data, _ := json.Marshal(map[string]json.RawMessage{"query": body.Query})
+			ops = append(ops, client.Count.WithBody(bytes.NewReader(data)))
+		}
+	} else if searchQuery != "" {
+		ops = append(ops, client.Count.WithQuery(searchQuery))
+	}
+	res, err := client.Count(ops...)
+	if err != nil {
+		klog.V(4).Infof("count documents failed: %v", err)
+		return 0
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil || res.IsError() {
+		klog.V(4).Infof("count documents failed: %v, %s", err, res.String())
+		return 0
+	}
+	var count struct {
+		Count int64 `json:"count"`
+	}
+	err = json.Unmarshal(data, &count)
+	if err != nil {
+		klog.V(4).Infof("count documents failed: %v", err)
+		return 0
+	}
+	return count.Count
+}
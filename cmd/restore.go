@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/cluster"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/loaddata"
+	"github.com/shinexia/elasticdump/pkg/manifest"
+	"github.com/shinexia/elasticdump/pkg/mapping"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+func newCmdRestore(_ io.Writer) *cobra.Command {
+	type extraOption struct {
+		InputFile  string
+		BufSize    int
+		QueueSize  int
+		QueueBytes int64
+		Delete     bool
+		SkipData   bool
+		IfExists   string
+	}
+	cfg := newBaseConfig()
+	cfg.Index = "*"
+	lopt := loaddata.NewLoadDataOption()
+	extra := &extraOption{
+		InputFile:  "elasticdump-backup.tar",
+		BufSize:    1024 * 1024 * 1024,
+		QueueSize:  0,
+		QueueBytes: 256 * 1024 * 1024,
+		Delete:     false,
+		SkipData:   false,
+		IfExists:   cluster.IfExistsOverwrite,
+	}
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "restore indexes, templates and pipelines from an archive written by backup",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			klog.V(5).Infof("cfg: %v, opt: %s, extra: %s", helpers.ToJSON(cfg), helpers.ToJSON(lopt), helpers.ToJSON(extra))
+			err = preprocessBaseConfig(cfg)
+			if err != nil {
+				return err
+			}
+			err = cluster.ValidateIfExists(extra.IfExists)
+			if err != nil {
+				return err
+			}
+			klog.V(5).Infof("cfg: %v\n", helpers.ToJSON(cfg))
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startTime := time.Now()
+			client, err := helpers.NewElasticSearchClient(cfg.Host, cfg.InsecureSkipVerify, helpers.WithoutTransportRetry())
+			if err != nil {
+				return err
+			}
+			target := targetVersion(client, lopt.Version)
+			lopt.Version = target
+			reader, err := helpers.OpenFile(extra.InputFile)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := reader.Close()
+				if err != nil {
+					klog.V(4).Infof("close reader failed: %v", err)
+				}
+			}()
+			// the archive is restored while streaming, backup writes the manifest first and every file in restore order
+			tr := tar.NewReader(reader)
+			header, err := tr.Next()
+			if err != nil {
+				return errors.Wrapf(err, "read archive: %s failed", extra.InputFile)
+			}
+			if header.Name != manifest.FileName {
+				return errors.Errorf("archive: %s does not start with %s", extra.InputFile, manifest.FileName)
+			}
+			m := &manifest.Manifest{}
+			err = json.NewDecoder(tr).Decode(m)
+			if err != nil {
+				return errors.Wrapf(err, "parse manifest of: %s failed", extra.InputFile)
+			}
+			objects := map[string]*manifest.Object{}
+			for _, obj := range m.Objects {
+				objects[obj.File] = obj
+			}
+			mappings := map[string]*manifest.Index{}
+			data := map[string]*manifest.Index{}
+			for _, index := range m.Indices {
+				mappings[index.Mapping] = index
+				data[index.Data] = index
+			}
+			nobjects, nindices := 0, 0
+			for {
+				header, err = tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return errors.Wrapf(err, "read archive: %s failed", extra.InputFile)
+				}
+				if obj, ok := objects[header.Name]; ok {
+					body, err := io.ReadAll(tr)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					ok, err := putObject(client, cluster.Kind(obj.Kind), obj.Name, body, extra.IfExists)
+					if err != nil {
+						return err
+					}
+					if ok {
+						klog.V(4).Infof("restore %s: %s\n", obj.Kind, obj.Name)
+						nobjects++
+					}
+					continue
+				}
+				if index, ok := mappings[header.Name]; ok {
+					ok, err := matchIndex(cfg.Index, index.Name)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						klog.V(4).Infof("skip index: %s\n", index.Name)
+						continue
+					}
+					if extra.Delete {
+						err = deleteIndexIfExists(client, index.Name)
+						if err != nil {
+							return err
+						}
+					}
+					body, err := io.ReadAll(tr)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					_, err = createIndex(client, index.Name, body, mapping.AliasRecreate, body, target)
+					if err != nil {
+						return errors.WithMessagef(err, "index: %s", index.Name)
+					}
+					nindices++
+					continue
+				}
+				if index, ok := data[header.Name]; ok {
+					ok, err := matchIndex(cfg.Index, index.Name)
+					if err != nil {
+						return err
+					}
+					if !ok || extra.SkipData {
+						continue
+					}
+					iopt := *lopt
+					iopt.Index = index.Name
+					queue := loaddata.NewBoundedDataQueue(extra.QueueSize, extra.QueueBytes, loaddata.HitSize)
+					err = loadDataReader(client, queue, tr, extra.BufSize, 0, &iopt)
+					if err != nil {
+						return errors.WithMessagef(err, "index: %s", index.Name)
+					}
+					continue
+				}
+				// backup repeats the manifest at the end with the document counts
+				if header.Name == manifest.FileName {
+					continue
+				}
+				klog.Infof("skip unknown file in archive: %s\n", header.Name)
+			}
+			cost := time.Since(startTime).Seconds()
+			klog.Infof("restore succeed, indices: %d, objects: %d, file: %s, cost: %.3fs\n", nindices, nobjects, extra.InputFile, cost)
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+	addBaseConfigFlags(cmd.Flags(), cfg)
+	flagSet := cmd.Flags()
+	flagSet.Lookup("index").Usage = "only restore indexes matching these names or wildcard patterns, separated by comma"
+
+	flagSet.StringVarP(&extra.InputFile, "file", "f", extra.InputFile, "input archive, gzip and zstd compressed archives are detected automatically")
+	flagSet.BoolVar(&extra.Delete, "delete", extra.Delete, "whether delete the indexes before restore")
+	flagSet.StringVar(&extra.IfExists, "if-exists", extra.IfExists, "what to do with a template or pipeline which already exists: overwrite or skip")
+	flagSet.BoolVar(&extra.SkipData, "skip-data", extra.SkipData, "only restore templates, pipelines and index mappings")
+
+	flagSet.StringVar(&lopt.Action, "action", lopt.Action, "bulk action: index (overwrite), create, update, upsert (update with doc_as_upsert) or delete (by _id)")
+	flagSet.IntVarP(&lopt.Batch, "batch", "b", lopt.Batch, "max documents per bulk request, also capped by --batch-bytes")
+	flagSet.Int64Var(&lopt.BatchBytes, "batch-bytes", lopt.BatchBytes, "max bytes of one bulk request, a request rejected with 413 is split, 0 is unlimited")
+	flagSet.IntVar(&lopt.Version, "target-version", lopt.Version, "major elasticsearch version of the target, mappings are migrated for it and before 7 bulk metadata has a _type, 0 detects it from the cluster and assumes the latest version when the info api fails")
+	flagSet.IntVar(&lopt.Workers, "workers", lopt.Workers, "number of concurrent bulk requests")
+	flagSet.IntVar(&lopt.MaxRetries, "max-retries", lopt.MaxRetries, "max retries of documents and bulk requests rejected with 429/5xx")
+	flagSet.IntVar(&extra.BufSize, "buf", extra.BufSize, "buffer size (byte) when split data file to lines, must bigger than the largest line")
+	flagSet.IntVar(&extra.QueueSize, "queue-size", extra.QueueSize, "max number of documents buffered between archive reader and bulk workers, 0 is unlimited")
+	flagSet.Int64Var(&extra.QueueBytes, "queue-bytes", extra.QueueBytes, "max bytes of documents buffered between archive reader and bulk workers, 0 is unlimited")
+	return cmd
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/ratelimit"
+
+	flag "github.com/spf13/pflag"
+)
+
+// throttleConfig rate limits of a dump, load or copy command
+type throttleConfig struct {
+	ratelimit.Limits
+	ControlFile string `json:"control_file"`
+}
+
+func addThrottleFlags(flagSet *flag.FlagSet, cfg *throttleConfig) {
+	flagSet.Float64Var(&cfg.DocsPerSec, "max-docs-per-sec", cfg.DocsPerSec, "max documents per second, 0 is unlimited")
+	flagSet.Float64Var(&cfg.BytesPerSec, "max-bytes-per-sec", cfg.BytesPerSec, "max bytes per second, 0 is unlimited")
+	flagSet.StringVar(&cfg.ControlFile, "throttle-file", cfg.ControlFile, `json file with {"max_docs_per_sec": n, "max_bytes_per_sec": n}, applied whenever it changes; SIGUSR1 halves and SIGUSR2 doubles the limits`)
+}
+
+// startLimiter returns nil when no limit is configured, otherwise a limiter adjustable at runtime until stop is called,
+// either way SIGUSR1 and SIGUSR2 are trapped until stop
+func startLimiter(cfg *throttleConfig) (limiter *ratelimit.Limiter, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if cfg.Limits.Unlimited() && cfg.ControlFile == "" {
+		go ratelimit.IgnoreSignals(ctx)
+		return nil, cancel
+	}
+	limiter = ratelimit.NewLimiter(cfg.Limits)
+	go ratelimit.Control(ctx, limiter, cfg.ControlFile, 5*time.Second)
+	return limiter, cancel
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cluster
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/pkg/errors"
+)
+
+const (
+	// IfExistsOverwrite replaces an existing object
+	IfExistsOverwrite = "overwrite"
+	// IfExistsSkip keeps an existing object untouched
+	IfExistsSkip = "skip"
+)
+
+// Dump objects keyed by kind and name, the file written by dump templates and dump pipelines
+type Dump map[Kind]map[string]json.RawMessage
+
+// ParseKinds parses comma separated kinds and returns them in the order of Kinds
+func ParseKinds(s string) ([]Kind, error) {
+	selected := map[Kind]bool{}
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if !isKind(Kind(k)) {
+			return nil, errors.Errorf("unknown kind: %s", k)
+		}
+		selected[Kind(k)] = true
+	}
+	kinds := []Kind{}
+	for _, k := range Kinds {
+		if selected[k] {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds, nil
+}
+
+func isKind(kind Kind) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateIfExists checks policy is IfExistsOverwrite or IfExistsSkip
+func ValidateIfExists(policy string) error {
+	switch policy {
+	case IfExistsOverwrite, IfExistsSkip:
+		return nil
+	default:
+		return errors.Errorf("unknown if-exists policy: %s, should be one of: overwrite, skip", policy)
+	}
+}
+
+// Exists reports whether the object of kind named name exists
+func Exists(client *elasticsearch.Client, kind Kind, name string) (bool, error) {
+	objects, err := GetObjects(client, kind, name)
+	if err != nil {
+		return false, err
+	}
+	_, ok := objects[name]
+	return ok, nil
+}
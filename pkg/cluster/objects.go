@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/pkg/errors"
+)
+
+// Kind of cluster level objects which are not part of an index
+type Kind string
+
+const (
+	KindIndexTemplate     Kind = "index_template"
+	KindComponentTemplate Kind = "component_template"
+	// KindLegacyTemplate templates of the deprecated _template api
+	KindLegacyTemplate Kind = "legacy_template"
+	KindPipeline       Kind = "pipeline"
+)
+
+// Kinds lists every kind in dependency order: pipelines and component templates are put before
+// the index templates referencing them
+var Kinds = []Kind{KindPipeline, KindComponentTemplate, KindIndexTemplate, KindLegacyTemplate}
+
+// readOnlyFields are returned by get but rejected by put
+var readOnlyFields = []string{"created_date", "created_date_millis", "modified_date", "modified_date_millis"}
+
+// GetObjects returns the objects of kind whose name matches name, wildcards allowed and empty for all,
+// as name to a body that can be put back
+func GetObjects(client *elasticsearch.Client, kind Kind, name string) (map[string]json.RawMessage, error) {
+	var (
+		res *esapi.Response
+		err error
+	)
+	switch kind {
+	case KindIndexTemplate:
+		o := []func(*esapi.IndicesGetIndexTemplateRequest){}
+		if name != "" {
+			o = append(o, client.Indices.GetIndexTemplate.WithName(name))
+		}
+		res, err = client.Indices.GetIndexTemplate(o...)
+	case KindComponentTemplate:
+		o := []func(*esapi.ClusterGetComponentTemplateRequest){}
+		if name != "" {
+			o = append(o, client.Cluster.GetComponentTemplate.WithName(name))
+		}
+		res, err = client.Cluster.GetComponentTemplate(o...)
+	case KindLegacyTemplate:
+		o := []func(*esapi.IndicesGetTemplateRequest){}
+		if name != "" {
+			o = append(o, client.Indices.GetTemplate.WithName(name))
+		}
+		res, err = client.Indices.GetTemplate(o...)
+	case KindPipeline:
+		o := []func(*esapi.IngestGetPipelineRequest){}
+		if name != "" {
+			o = append(o, client.Ingest.GetPipeline.WithPipelineID(name))
+		}
+		res, err = client.Ingest.GetPipeline(o...)
+	default:
+		return nil, errors.Errorf("unknown kind: %s", kind)
+	}
+	if err != nil {
+		return nil, errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// nothing matches the name
+	if res.StatusCode == http.StatusNotFound {
+		return map[string]json.RawMessage{}, nil
+	}
+	if res.IsError() {
+		return nil, errors.Errorf("get %s: %s failed, status: %d, body: %s", kind, name, res.StatusCode, string(body))
+	}
+	objects, err := parseObjects(kind, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, obj := range objects {
+		objects[name], err = removeFields(obj, readOnlyFields...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func parseObjects(kind Kind, body []byte) (map[string]json.RawMessage, error) {
+	objects := map[string]json.RawMessage{}
+	switch kind {
+	case KindIndexTemplate:
+		var res struct {
+			IndexTemplates []struct {
+				Name          string          `json:"name"`
+				IndexTemplate json.RawMessage `json:"index_template"`
+			} `json:"index_templates"`
+		}
+		err := json.Unmarshal(body, &res)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, t := range res.IndexTemplates {
+			objects[t.Name] = t.IndexTemplate
+		}
+	case KindComponentTemplate:
+		var res struct {
+			ComponentTemplates []struct {
+				Name              string          `json:"name"`
+				ComponentTemplate json.RawMessage `json:"component_template"`
+			} `json:"component_templates"`
+		}
+		err := json.Unmarshal(body, &res)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, t := range res.ComponentTemplates {
+			objects[t.Name] = t.ComponentTemplate
+		}
+	default:
+		// legacy templates and pipelines are returned as a map keyed by name
+		err := json.Unmarshal(body, &objects)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return objects, nil
+}
+
+// PutObject creates the object of kind, or replaces it unless create is set, reports whether it was put:
+// with create an existing object is kept and false is returned, pipelines have no create flag so they are looked up first
+func PutObject(client *elasticsearch.Client, kind Kind, name string, body []byte, create bool) (bool, error) {
+	var (
+		res *esapi.Response
+		err error
+	)
+	switch kind {
+	case KindIndexTemplate:
+		res, err = client.Indices.PutIndexTemplate(name, bytes.NewReader(body), client.Indices.PutIndexTemplate.WithCreate(create))
+	case KindComponentTemplate:
+		res, err = client.Cluster.PutComponentTemplate(name, bytes.NewReader(body), client.Cluster.PutComponentTemplate.WithCreate(create))
+	case KindLegacyTemplate:
+		res, err = client.Indices.PutTemplate(name, bytes.NewReader(body), client.Indices.PutTemplate.WithCreate(create))
+	case KindPipeline:
+		if create {
+			exists, err := Exists(client, kind, name)
+			if err != nil || exists {
+				return false, err
+			}
+		}
+		res, err = client.Ingest.PutPipeline(name, bytes.NewReader(body))
+	default:
+		return false, errors.Errorf("unknown kind: %s", kind)
+	}
+	if err != nil {
+		return false, errors.Cause(err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		msg := res.String()
+		// a template put with create is rejected with 400 "... already exists"
+		if create && res.StatusCode == http.StatusBadRequest && strings.Contains(msg, "already exists") {
+			return false, nil
+		}
+		return false, errors.Errorf("put %s: %s failed: %s", kind, name, msg)
+	}
+	return true, nil
+}
+
+// IsManaged reports whether the object is installed and managed by elasticsearch itself
+func IsManaged(body json.RawMessage) bool {
+	var obj struct {
+		Meta struct {
+			Managed bool `json:"managed"`
+		} `json:"_meta"`
+	}
+	err := json.Unmarshal(body, &obj)
+	return err == nil && obj.Meta.Managed
+}
+
+// removeFields deletes the top level keys from a json object
+func removeFields(body json.RawMessage, keys ...string) (json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(body, &obj)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, key := range keys {
+		delete(obj, key)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
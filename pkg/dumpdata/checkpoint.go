@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package dumpdata
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// Checkpoint the saved progress of a dump: where to continue reading and how much of the output is consistent
+type Checkpoint struct {
+	Cursor
+	File     string `json:"file"`
+	Compress string `json:"compress"`
+	// Offset size of the output file written up to Cursor
+	Offset    int64     `json:"offset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read checkpoint: %s failed", file)
+	}
+	cp := &Checkpoint{}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse checkpoint: %s failed", file)
+	}
+	return cp, nil
+}
+
+// SaveCheckpoint replaces file with cp atomically, a crash never leaves a partial checkpoint
+func SaveCheckpoint(file string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return helpers.WriteFileAtomic(file, data)
+}
+
+// Checkpointer saves the cursor of a dump together with the output offset of writer at most once per interval
+type Checkpointer struct {
+	file     string
+	interval time.Duration
+	writer   *LazyDataWriter
+	template Checkpoint
+	cursor   *Cursor
+	saved    time.Time
+}
+
+func NewCheckpointer(file string, interval time.Duration, writer *LazyDataWriter) *Checkpointer {
+	return &Checkpointer{
+		file:     file,
+		interval: interval,
+		writer:   writer,
+		template: Checkpoint{
+			File:     writer.outputFile,
+			Compress: writer.compression,
+		},
+		saved: time.Now(),
+	}
+}
+
+// OnCursor is a CursorFunc
+func (c *Checkpointer) OnCursor(cursor *Cursor) error {
+	c.cursor = cursor
+	if time.Since(c.saved) < c.interval {
+		return nil
+	}
+	return c.Save()
+}
+
+// Save writes the last cursor now
+func (c *Checkpointer) Save() error {
+	if c.cursor == nil {
+		return nil
+	}
+	offset, err := c.writer.Sync()
+	if err != nil {
+		return err
+	}
+	cp := c.template
+	cp.Cursor = *c.cursor
+	cp.Offset = offset
+	cp.UpdatedAt = time.Now()
+	err = SaveCheckpoint(c.file, &cp)
+	if err != nil {
+		return err
+	}
+	c.saved = cp.UpdatedAt
+	klog.V(4).Infof("checkpoint saved, count: %d, offset: %d, file: %s\n", cp.Count, cp.Offset, c.file)
+	return nil
+}
+
+// Remove deletes the checkpoint of a finished dump
+func (c *Checkpointer) Remove() error {
+	err := os.Remove(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -12,68 +12,211 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/metrics"
+	"github.com/shinexia/elasticdump/pkg/progress"
+	"github.com/shinexia/elasticdump/pkg/ratelimit"
 
 	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/elastic/go-elasticsearch/v9/esapi"
 	"github.com/pkg/errors"
+	"k8s.io/klog"
 )
 
 const (
 	QUERY_ALL = "*:*"
 )
 
+const (
+	// ModeScroll pages with the scroll api
+	ModeScroll = "scroll"
+	// ModePit pages with search_after inside a point in time
+	ModePit = "pit"
+)
+
+// errLimitReached stops the scroll once DumpDataOption.Limit hits were written
+var errLimitReached = errors.New("limit reached")
+
 type DumpDataOption struct {
 	Limit      int
 	TimeoutSec int
+	// Mode how to page through the index: ModeScroll or ModePit
+	Mode string
+	// Slices number of sliced scrolls read concurrently, <= 1 means a single scroll
+	Slices int
+	// Ordered write pages of slices in round-robin order instead of arrival order
+	Ordered bool
+	// PitKeepAliveSec keep alive of the point in time of a ModePit dump, 0 uses TimeoutSec.
+	// A resumable dump needs it longer than the time it takes to restart a failed dump
+	PitKeepAliveSec int
+	// Cursor continues a ModePit dump from a saved position, optional
+	Cursor *Cursor
+	// OnCursor receives the position of a ModePit dump after every written page, optional
+	OnCursor CursorFunc `json:"-"`
+	// WithVersion requests the _version, _seq_no and _primary_term of every hit
+	WithVersion bool
+	// Limiter throttles the pages read, optional
+	Limiter *ratelimit.Limiter `json:"-"`
+	// Progress counts the written hits, optional
+	Progress *progress.Progress `json:"-"`
+	// Metrics counts the read and written hits and the page latency, optional
+	Metrics *metrics.Stage `json:"-"`
 }
 
 func NewDumpDataOption() *DumpDataOption {
 	return &DumpDataOption{
 		Limit:      0,
 		TimeoutSec: 60,
+		Mode:       ModeScroll,
+		Slices:     1,
+		Ordered:    false,
 	}
 }
 
 func DumpData(client *elasticsearch.Client, dumpOption *DumpDataOption, writeFunc WriteDataFunc, o ...func(*esapi.SearchRequest)) (int, error) {
-	res, err := client.Search(o...)
+	if dumpOption.Mode != ModeScroll && dumpOption.Mode != ModePit {
+		return 0, errors.Errorf("unknown dump mode: %s", dumpOption.Mode)
+	}
+	if dumpOption.Cursor != nil && (dumpOption.Mode != ModePit || dumpOption.Slices > 1) {
+		return 0, errors.New("only a point in time dump without slices can be resumed")
+	}
+	ctx := context.Background()
+	write := writeFunc
+	if dumpOption.Progress != nil || dumpOption.Metrics != nil {
+		write = func(hits []json.RawMessage) (int, error) {
+			n, err := writeFunc(hits)
+			size := 0
+			for _, hit := range hits[:n] {
+				size += len(hit)
+			}
+			dumpOption.Progress.Add(n, size)
+			dumpOption.Metrics.AddWritten(n)
+			return n, err
+		}
+	}
+	writer := &limitWriter{limit: dumpOption.Limit, write: write}
+	if dumpOption.Cursor != nil {
+		writer.count = dumpOption.Cursor.Count
+	}
+	var err error
+	if dumpOption.Slices > 1 {
+		err = dumpSlices(ctx, client, dumpOption, writer.Write, o...)
+	} else {
+		err = readData(ctx, client, dumpOption, "", throttle(ctx, dumpOption.Limiter, writer.Write), o...)
+	}
+	if errors.Is(err, errLimitReached) {
+		err = nil
+	}
+	return writer.count, err
+}
+
+// throttle makes writeFunc wait for limiter first, the cursors wait for their pages to be written,
+// so throttling the writes throttles the reads. The wait ends once ctx is done, a nil limiter leaves writeFunc as is
+func throttle(ctx context.Context, limiter *ratelimit.Limiter, writeFunc WriteDataFunc) WriteDataFunc {
+	if limiter == nil {
+		return writeFunc
+	}
+	return func(hits []json.RawMessage) (int, error) {
+		size := 0
+		for _, hit := range hits {
+			size += len(hit)
+		}
+		err := limiter.Wait(ctx, len(hits), size)
+		if err != nil {
+			return 0, err
+		}
+		return writeFunc(hits)
+	}
+}
+
+// readData reads all hits of one cursor with the configured mode, pitID is a point in time shared by
+// the slices of a ModePit dump, empty for a cursor of its own
+func readData(ctx context.Context, client *elasticsearch.Client, dumpOption *DumpDataOption, pitID string, writeFunc WriteDataFunc, o ...func(*esapi.SearchRequest)) error {
+	if dumpOption.Mode == ModePit {
+		return pitData(ctx, client, dumpOption, pitID, writeFunc, o...)
+	}
+	return scrollData(ctx, client, dumpOption, writeFunc, o...)
+}
+
+// scrollData walks a single scroll cursor until it is exhausted or writeFunc fails
+func scrollData(ctx context.Context, client *elasticsearch.Client, dumpOption *DumpDataOption, writeFunc WriteDataFunc, o ...func(*esapi.SearchRequest)) error {
+	pageStart := time.Now()
+	res, err := client.Search(append(o[:len(o):len(o)], client.Search.WithContext(ctx))...)
 	if err != nil {
-		return 0, errors.Cause(err)
+		return errors.Cause(err)
 	}
-	count := 0
+	scrollID := ""
+	defer func() {
+		if scrollID != "" {
+			clearScroll(client, scrollID)
+		}
+	}()
 	for {
 		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
-			return count, errors.Cause(err)
+			return errors.Cause(err)
 		}
+		dumpOption.Metrics.ObservePage(time.Since(pageStart))
 		if res.IsError() {
-			return count, errors.New(res.String())
+			return errors.New(res.String())
 		}
 		response := &ScrollResponse{}
 		err = json.Unmarshal(body, response)
 		if err != nil {
-			return count, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
+		scrollID = response.ScrollID
 		hits := response.Hits.Hits
-		nhits := len(hits)
-		if nhits == 0 {
-			return count, nil
+		dumpOption.Metrics.AddRead(len(hits))
+		if len(hits) == 0 {
+			return nil
 		}
-		if dumpOption.Limit > 0 && count+nhits > dumpOption.Limit {
-			hits = hits[:count+nhits-dumpOption.Limit]
-		}
-		n, err := writeFunc(hits)
-		count += n
+		_, err = writeFunc(hits)
 		if err != nil {
-			return count, err
-		}
-		if dumpOption.Limit > 0 && count >= dumpOption.Limit {
-			break
+			return err
 		}
-		scrollReq := []byte(fmt.Sprintf(`{"scroll": "%ds","scroll_id": "%s"}`, dumpOption.TimeoutSec, response.ScrollID))
-		res, err = client.Scroll(client.Scroll.WithContext(context.Background()), client.Scroll.WithBody(bytes.NewReader(scrollReq)))
+		scrollReq := []byte(fmt.Sprintf(`{"scroll": "%ds","scroll_id": "%s"}`, dumpOption.TimeoutSec, scrollID))
+		pageStart = time.Now()
+		res, err = client.Scroll(client.Scroll.WithContext(ctx), client.Scroll.WithBody(bytes.NewReader(scrollReq)))
 		if err != nil {
-			return count, errors.Cause(err)
+			return errors.Cause(err)
 		}
 	}
-	return count, nil
+}
+
+// clearScroll releases the server side scroll context, failures are only logged
+func clearScroll(client *elasticsearch.Client, scrollID string) {
+	res, err := client.ClearScroll(client.ClearScroll.WithScrollID(scrollID))
+	if err != nil {
+		klog.V(4).Infof("clear scroll failed: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		klog.V(4).Infof("clear scroll failed: %s", res.String())
+	}
+}
+
+// limitWriter counts written hits and truncates the output at limit
+type limitWriter struct {
+	limit int
+	count int
+	write WriteDataFunc
+}
+
+func (w *limitWriter) Write(hits []json.RawMessage) (int, error) {
+	if w.limit > 0 && w.count+len(hits) > w.limit {
+		hits = hits[:w.limit-w.count]
+	}
+	n, err := w.write(hits)
+	w.count += n
+	if err != nil {
+		return n, err
+	}
+	if w.limit > 0 && w.count >= w.limit {
+		return n, errLimitReached
+	}
+	return n, nil
 }
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package dumpdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// Cursor the position of a point in time dump, enough to continue it while the point in time is alive
+type Cursor struct {
+	PitID       string          `json:"pit_id"`
+	SearchAfter json.RawMessage `json:"search_after,omitempty"`
+	// Count number of hits written before this position
+	Count int `json:"count"`
+}
+
+type CursorFunc func(cursor *Cursor) error
+
+// pitData opens a point in time on the searched index, or continues dumpOption.Cursor, and pages
+// through it with search_after. The point in time is closed when the dump finishes or fails,
+// except that a failed dump reporting its cursor keeps it alive to be resumed.
+// A non empty sharedPit is a point in time opened and closed by the caller for all slices.
+func pitData(ctx context.Context, client *elasticsearch.Client, dumpOption *DumpDataOption, sharedPit string, writeFunc WriteDataFunc, o ...func(*esapi.SearchRequest)) (err error) {
+	req := &esapi.SearchRequest{}
+	for _, f := range o {
+		f(req)
+	}
+	body, err := readBody(req.Body)
+	if err != nil {
+		return err
+	}
+	count := 0
+	pitID := sharedPit
+	if sharedPit != "" {
+		klog.V(4).Infof("slice point in time: %s\n", pitID)
+	} else if cursor := dumpOption.Cursor; cursor != nil {
+		pitID = cursor.PitID
+		count = cursor.Count
+		if len(cursor.SearchAfter) > 0 {
+			body["search_after"] = cursor.SearchAfter
+		}
+		klog.Infof("resume point in time: %s, count: %d\n", pitID, count)
+	} else {
+		keepAlive := time.Duration(dumpOption.pitKeepAlive()) * time.Second
+		pitID, err = openPointInTime(ctx, client, req.Index, keepAlive)
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if sharedPit != "" {
+			return
+		}
+		if err != nil && !errors.Is(err, errLimitReached) && dumpOption.OnCursor != nil {
+			klog.Infof("keep point in time: %s for resuming\n", pitID)
+			return
+		}
+		closePointInTime(client, pitID)
+	}()
+	// a pit search must not name the index nor use scroll
+	req.Index = nil
+	req.Scroll = 0
+	if _, ok := body["sort"]; !ok {
+		body["sort"] = json.RawMessage(`[{"_shard_doc": "asc"}]`)
+	}
+	for {
+		pit, err := json.Marshal(map[string]string{"id": pitID, "keep_alive": fmt.Sprintf("%ds", dumpOption.pitKeepAlive())})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		body["pit"] = pit
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		req.Body = bytes.NewReader(data)
+		pageStart := time.Now()
+		res, err := req.Do(ctx, client)
+		if err != nil {
+			return errors.Cause(err)
+		}
+		resBody, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return errors.Cause(err)
+		}
+		dumpOption.Metrics.ObservePage(time.Since(pageStart))
+		if res.IsError() {
+			if res.StatusCode == http.StatusNotFound && dumpOption.Cursor != nil {
+				return errors.Errorf("point in time: %s expired, can not resume: %s", pitID, res.String())
+			}
+			return errors.New(res.String())
+		}
+		response := &ScrollResponse{}
+		err = json.Unmarshal(resBody, response)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if response.PitID != "" {
+			pitID = response.PitID
+		}
+		hits := response.Hits.Hits
+		dumpOption.Metrics.AddRead(len(hits))
+		if len(hits) == 0 {
+			return nil
+		}
+		n, err := writeFunc(hits)
+		count += n
+		if err != nil {
+			return err
+		}
+		searchAfter, err := hitSort(hits[len(hits)-1])
+		if err != nil {
+			return err
+		}
+		body["search_after"] = searchAfter
+		if dumpOption.OnCursor != nil {
+			err = dumpOption.OnCursor(&Cursor{PitID: pitID, SearchAfter: searchAfter, Count: count})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pitKeepAlive returns the keep alive (second) of the point in time
+func (o *DumpDataOption) pitKeepAlive() int {
+	if o.PitKeepAliveSec > 0 {
+		return o.PitKeepAliveSec
+	}
+	return o.TimeoutSec
+}
+
+// hitSort returns the sort values of a hit, used as search_after of the next page
+func hitSort(hit json.RawMessage) (json.RawMessage, error) {
+	var h struct {
+		Sort json.RawMessage `json:"sort"`
+	}
+	err := json.Unmarshal(hit, &h)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(h.Sort) == 0 {
+		return nil, errors.Errorf("hit without sort values: %s", string(hit))
+	}
+	return h.Sort, nil
+}
+
+func openPointInTime(ctx context.Context, client *elasticsearch.Client, index []string, keepAlive time.Duration) (string, error) {
+	if len(index) == 0 {
+		return "", errors.New("point in time requires an index")
+	}
+	res, err := client.OpenPointInTime(index, keepAlive, client.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if res.IsError() || err != nil {
+		return "", errors.Errorf("open point in time failed, status: %d, body: %s", res.StatusCode, string(body))
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	err = json.Unmarshal(body, &pit)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	klog.V(4).Infof("opened point in time: %s\n", pit.ID)
+	return pit.ID, nil
+}
+
+// closePointInTime releases the point in time, failures are only logged
+func closePointInTime(client *elasticsearch.Client, pitID string) {
+	data, err := json.Marshal(map[string]string{"id": pitID})
+	if err != nil {
+		klog.V(4).Infof("close point in time failed: %v", err)
+		return
+	}
+	res, err := client.ClosePointInTime(bytes.NewReader(data))
+	if err != nil {
+		klog.V(4).Infof("close point in time failed: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		klog.V(4).Infof("close point in time failed: %s", res.String())
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package dumpdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// dumpSlices runs dumpOption.Slices sliced scrolls concurrently and funnels their hits into writeFunc,
+// the first failing slice cancels the others. In ModePit all slices read one point in time, so they
+// see the same snapshot of the index
+func dumpSlices(ctx context.Context, client *elasticsearch.Client, dumpOption *DumpDataOption, writeFunc WriteDataFunc, o ...func(*esapi.SearchRequest)) error {
+	base, err := searchBody(o)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pitID := ""
+	if dumpOption.Mode == ModePit {
+		req := &esapi.SearchRequest{}
+		for _, f := range o {
+			f(req)
+		}
+		pitID, err = openPointInTime(ctx, client, req.Index, time.Duration(dumpOption.pitKeepAlive())*time.Second)
+		if err != nil {
+			return err
+		}
+		defer closePointInTime(client, pitID)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		mu       sync.Mutex
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	total := dumpOption.Slices
+	pages := make([]chan []json.RawMessage, total)
+	for id := 0; id < total; id++ {
+		body, err := sliceBody(base, id, total)
+		if err != nil {
+			fail(err)
+			break
+		}
+		var sliceWrite WriteDataFunc
+		if dumpOption.Ordered {
+			ch := make(chan []json.RawMessage, 1)
+			pages[id] = ch
+			sliceWrite = func(hits []json.RawMessage) (int, error) {
+				select {
+				case ch <- hits:
+					return len(hits), nil
+				case <-ctx.Done():
+					return 0, ctx.Err()
+				}
+			}
+		} else {
+			sliceWrite = func(hits []json.RawMessage) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return writeFunc(hits)
+			}
+		}
+		ops := append(o[:len(o):len(o)], client.Search.WithBody(bytes.NewReader(body)))
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if ch := pages[id]; ch != nil {
+				defer close(ch)
+			}
+			count := 0
+			// a slice blocked by the limiter gives up as soon as another one fails
+			err := readData(ctx, client, dumpOption, pitID, throttle(ctx, dumpOption.Limiter, func(hits []json.RawMessage) (int, error) {
+				n, err := sliceWrite(hits)
+				count += n
+				klog.V(4).Infof("slice %d/%d dumped: %d/%d\n", id, total, n, count)
+				return n, err
+			}), ops...)
+			if err != nil {
+				fail(errors.WithMessagef(err, "slice %d/%d", id, total))
+				return
+			}
+			klog.Infof("slice %d/%d finished, total: %d\n", id, total, count)
+		}(id)
+	}
+	if dumpOption.Ordered {
+		err := writeOrdered(pages, writeFunc)
+		if err != nil {
+			fail(err)
+		}
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// writeOrdered takes one page from every unfinished slice in turn, so the output order
+// only depends on the data and not on the speed of each slice
+func writeOrdered(pages []chan []json.RawMessage, writeFunc WriteDataFunc) error {
+	active := make([]chan []json.RawMessage, 0, len(pages))
+	for _, ch := range pages {
+		if ch != nil {
+			active = append(active, ch)
+		}
+	}
+	for len(active) > 0 {
+		next := active[:0]
+		for _, ch := range active {
+			hits, ok := <-ch
+			if !ok {
+				continue
+			}
+			_, err := writeFunc(hits)
+			if err != nil {
+				return err
+			}
+			next = append(next, ch)
+		}
+		active = next
+	}
+	return nil
+}
+
+// searchBody extracts the json body configured by the search options, an empty body is allowed
+func searchBody(o []func(*esapi.SearchRequest)) (map[string]json.RawMessage, error) {
+	req := &esapi.SearchRequest{}
+	for _, f := range o {
+		f(req)
+	}
+	return readBody(req.Body)
+}
+
+func readBody(in io.Reader) (map[string]json.RawMessage, error) {
+	body := map[string]json.RawMessage{}
+	if in == nil {
+		return body, nil
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return body, nil
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "search body: %s", string(data))
+	}
+	return body, nil
+}
+
+// sliceBody returns a copy of base with the slice clause set
+func sliceBody(base map[string]json.RawMessage, id, total int) ([]byte, error) {
+	body := make(map[string]json.RawMessage, len(base)+1)
+	for k, v := range base {
+		body[k] = v
+	}
+	slice, err := json.Marshal(map[string]int{"id": id, "max": total})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	body["slice"] = slice
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package dumpdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/ratelimit"
+
+	"github.com/elastic/go-elasticsearch/v9"
+)
+
+// page returns a scroll response of n hits
+func page(n int) []byte {
+	hits := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hits = append(hits, fmt.Sprintf(`{"_id":"%d","_source":{}}`, i))
+	}
+	return []byte(`{"_scroll_id":"scroll","hits":{"hits":[` + strings.Join(hits, ",") + `]}}`)
+}
+
+// TestFailedSliceStopsThrottledSlices a slice waiting for the limiter gives up when another slice fails
+func TestFailedSliceStopsThrottledSlices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodDelete:
+			_, _ = w.Write([]byte(`{}`))
+		case bytes.Contains(body, []byte(`"id":0`)):
+			// fails once the other slice waits for the limiter
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"broken slice","status":400}`))
+		default:
+			_, _ = w.Write(page(10))
+		}
+	}))
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := NewDumpDataOption()
+	opt.Slices = 2
+	// a page of 10 hits takes 10s
+	opt.Limiter = ratelimit.NewLimiter(ratelimit.Limits{DocsPerSec: 1})
+	start := time.Now()
+	_, err = DumpData(client, opt, func(hits []json.RawMessage) (int, error) {
+		return len(hits), nil
+	}, client.Search.WithIndex("source"), client.Search.WithScroll(time.Minute))
+	if err == nil {
+		t.Fatal("dump with a failed slice succeeded")
+	}
+	if cost := time.Since(start); cost > 3*time.Second {
+		t.Errorf("dump returned after %v, the throttled slice kept waiting", cost)
+	}
+}
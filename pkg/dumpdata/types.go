@@ -12,6 +12,7 @@ import (
 
 type ScrollResponse struct {
 	ScrollID string          `json:"_scroll_id"`
+	PitID    string          `json:"pit_id"`
 	Took     int             `json:"took"`
 	TimeOut  bool            `json:"time_out"`
 	Shards   json.RawMessage `json:"_shards"`
@@ -8,8 +8,11 @@ package dumpdata
 
 import (
 	"encoding/json"
+	"io"
 	"os"
 
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
 	"github.com/pkg/errors"
 )
 
@@ -19,32 +22,74 @@ var (
 
 type WriteDataFunc func(hits []json.RawMessage) (int, error)
 
+// LazyDataWriter writes hits as ndjson, compressed with helpers.CompressGzip or helpers.CompressZstd,
+// the file is only created on the first write
 type LazyDataWriter struct {
-	outputFile string
-	file       *os.File
+	outputFile  string
+	compression string
+	offset      int64
+	file        *os.File
+	// w compresses into file, it is recreated after every Sync
+	w io.WriteCloser
 }
 
-func NewLazyDataWriter(outputFile string) *LazyDataWriter {
+func NewLazyDataWriter(outputFile string, compression string) *LazyDataWriter {
 	return &LazyDataWriter{
-		outputFile: outputFile,
+		outputFile:  outputFile,
+		compression: compression,
+	}
+}
+
+// Resume makes the writer continue the existing output file at offset, everything after offset is dropped
+// right away, so the file is cut back even when nothing is written anymore
+func (w *LazyDataWriter) Resume(offset int64) error {
+	f, err := os.OpenFile(w.outputFile, os.O_WRONLY, 0666)
+	if err != nil {
+		return errors.Cause(err)
+	}
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "resume file: %s at: %d", w.outputFile, offset)
+	}
+	w.offset = offset
+	w.file = f
+	return nil
+}
+
+func (w *LazyDataWriter) open() error {
+	f, err := os.Create(w.outputFile)
+	if err != nil {
+		return errors.Cause(err)
 	}
+	w.file = f
+	return nil
 }
 
 func (w *LazyDataWriter) Write(hits []json.RawMessage) (int, error) {
 	if w.file == nil {
-		f, err := os.Create(w.outputFile)
+		err := w.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if w.w == nil {
+		cw, err := helpers.NewCompressWriter(w.file, w.compression)
 		if err != nil {
-			return 0, errors.Cause(err)
+			return 0, err
 		}
-		w.file = f
+		w.w = cw
 	}
 	count := 0
 	for _, hit := range hits {
-		_, err := w.file.Write(hit)
+		_, err := w.w.Write(hit)
 		if err != nil {
 			return count, errors.Cause(err)
 		}
-		_, err = w.file.Write(newLine)
+		_, err = w.w.Write(newLine)
 		if err != nil {
 			return count, errors.Cause(err)
 		}
@@ -53,11 +98,43 @@ func (w *LazyDataWriter) Write(hits []json.RawMessage) (int, error) {
 	return count, nil
 }
 
+// Sync finishes the current compression frame, flushes the file and returns its size,
+// the output can be truncated to any returned offset and resumed from there
+func (w *LazyDataWriter) Sync() (int64, error) {
+	if w.file == nil {
+		return w.offset, nil
+	}
+	if w.w != nil {
+		cw := w.w
+		w.w = nil
+		err := cw.Close()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	err := w.file.Sync()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return offset, nil
+}
+
 func (w *LazyDataWriter) Close() error {
-	if w.file != nil {
-		f := w.file
-		w.file = nil
-		return f.Close()
+	if w.file == nil {
+		return nil
 	}
-	return nil
+	f, cw := w.file, w.w
+	w.file, w.w = nil, nil
+	if cw != nil {
+		err := cw.Close()
+		if err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+	}
+	return f.Close()
 }
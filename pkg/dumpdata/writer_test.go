@@ -0,0 +1,121 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package dumpdata
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+)
+
+func hits(docs ...string) []json.RawMessage {
+	res := make([]json.RawMessage, 0, len(docs))
+	for _, doc := range docs {
+		res = append(res, json.RawMessage(doc))
+	}
+	return res
+}
+
+func readAll(t *testing.T, file string) string {
+	t.Helper()
+	reader, err := helpers.OpenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestResumeWithoutHits resumes a dump which crashed after its checkpoint, when no hits remain
+// everything written after the checkpoint must still be dropped
+func TestResumeWithoutHits(t *testing.T) {
+	for _, compression := range []string{helpers.CompressNone, helpers.CompressGzip, helpers.CompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "data.json"+helpers.CompressionExt(compression))
+			w := NewLazyDataWriter(file, compression)
+			_, err := w.Write(hits(`{"_id":"1"}`, `{"_id":"2"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset, err := w.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// crash in the middle of the next frame, the compressor is never finished
+			_, err = w.Write(hits(`{"_id":"3"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = w.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = w.Write(hits(`{"_id":"4"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.file.Close()
+
+			w = NewLazyDataWriter(file, compression)
+			err = w.Resume(offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "{\"_id\":\"1\"}\n{\"_id\":\"2\"}\n"
+			if got := readAll(t, file); got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResumeAppends(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.json.gz")
+	w := NewLazyDataWriter(file, helpers.CompressGzip)
+	_, err := w.Write(hits(`{"_id":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := w.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(hits(`{"_id":"2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.file.Close()
+
+	w = NewLazyDataWriter(file, helpers.CompressGzip)
+	err = w.Resume(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(hits(`{"_id":"3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"_id\":\"1\"}\n{\"_id\":\"3\"}\n"
+	if got := readAll(t, file); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionExt returns the file extension of compression
+func CompressionExt(compression string) string {
+	switch compression {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// FindCompressedFile returns file, or when it does not exist the file.gz or file.zst written by a compressed dump,
+// file itself when none exists
+func FindCompressedFile(file string) string {
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+	for _, compression := range []string{CompressGzip, CompressZstd} {
+		name := file + CompressionExt(compression)
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return file
+}
+
+// ResolveCompression validates compression, an empty one is chosen by the extension of file
+func ResolveCompression(compression, file string) (string, error) {
+	switch compression {
+	case CompressNone, CompressGzip, CompressZstd:
+		return compression, nil
+	case "":
+		if strings.HasSuffix(file, ".gz") {
+			return CompressGzip, nil
+		}
+		if strings.HasSuffix(file, ".zst") {
+			return CompressZstd, nil
+		}
+		return CompressNone, nil
+	default:
+		return "", errors.Errorf("unknown compression: %s, should be one of: none, gzip, zstd", compression)
+	}
+}
+
+// NewCompressWriter wraps w with compression, closing the returned writer flushes it but does not close w
+func NewCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return zw, nil
+	case CompressNone, "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, errors.Errorf("unknown compression: %s", compression)
+	}
+}
+
+// NewDecompressReader detects gzip or zstd input by its magic bytes, other input is returned as is
+func NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// CreateFile creates file and compresses what is written to it
+func CreateFile(file, compression string) (io.WriteCloser, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create file: %s failed", file)
+	}
+	w, err := NewCompressWriter(f, compression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileWriter{WriteCloser: w, file: f}, nil
+}
+
+// OpenFile opens file and transparently decompresses it
+func OpenFile(file string) (io.ReadCloser, error) {
+	return OpenTrackedFile(file, nil)
+}
+
+// OpenTrackedFile is OpenFile reading the raw file through track, which receives the file and its size, optional
+func OpenTrackedFile(file string, track func(r io.Reader, size int64) io.Reader) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "file: %s", file)
+	}
+	var in io.Reader = f
+	if track != nil {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, errors.WithMessagef(err, "file: %s", file)
+		}
+		in = track(f, info.Size())
+	}
+	r, err := NewDecompressReader(in)
+	if err != nil {
+		f.Close()
+		return nil, errors.WithMessagef(err, "file: %s", file)
+	}
+	return &fileReader{ReadCloser: r, file: f}, nil
+}
+
+// ReadFile reads the whole file, decompressing it if needed
+func ReadFile(file string) ([]byte, error) {
+	r, err := OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file: %s failed", file)
+	}
+	return data, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// fileWriter closes the compressor before the file
+type fileWriter struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (w *fileWriter) Close() error {
+	err := w.WriteCloser.Close()
+	ferr := w.file.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ferr)
+}
+
+// fileReader closes the decompressor before the file
+type fileReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *fileReader) Close() error {
+	err := r.ReadCloser.Close()
+	ferr := r.file.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ferr)
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"plain-data.json", "plain-data.json.gz", "gzip-data.json.gz", "zstd-data.json.zst"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"plain-data.json", "plain-data.json"},
+		{"gzip-data.json", "gzip-data.json.gz"},
+		{"zstd-data.json", "zstd-data.json.zst"},
+		{"missing-data.json", "missing-data.json"},
+	}
+	for _, tt := range tests {
+		got := FindCompressedFile(filepath.Join(dir, tt.file))
+		if got != filepath.Join(dir, tt.want) {
+			t.Errorf("FindCompressedFile(%s) = %s, want %s", tt.file, filepath.Base(got), tt.want)
+		}
+	}
+}
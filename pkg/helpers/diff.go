@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext unchanged lines shown around a change
+const diffContext = 3
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Diff returns a unified diff of the lines of a and b, empty if they are equal
+func Diff(aName, bName, a, b string) string {
+	lines := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+	changed := false
+	for _, l := range lines {
+		if l.op != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	// line numbers of lines[i] in a and b
+	aLine, bLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, l := range lines {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if l.op != '+' {
+			aLine[i+1]++
+		}
+		if l.op != '-' {
+			bLine[i+1]++
+		}
+	}
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		start := max(i-diffContext, 0)
+		end := i
+		// extend the hunk while the next change is within two contexts
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(lines))
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aLine[start]+1, aLine[end]-aLine[start], bLine[start]+1, bLine[end]-bLine[start])
+		for _, l := range lines[start:end] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// diffLines aligns a and b by their longest common subsequence, found with the linear space algorithm
+// of Hirschberg, so large documents do not need an len(a)*len(b) table
+func diffLines(a, b []string) []diffLine {
+	// compare lines by id instead of by content
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &differ{a: a, b: b, ai: intern(a), bi: intern(b)}
+	d.lines = make([]diffLine, 0, max(len(a), len(b)))
+	d.diff(0, len(a), 0, len(b))
+	return d.lines
+}
+
+type differ struct {
+	a, b   []string
+	ai, bi []int
+	lines  []diffLine
+}
+
+// same appends the unchanged lines a[from:to]
+func (d *differ) same(from, to int) {
+	for i := from; i < to; i++ {
+		d.lines = append(d.lines, diffLine{' ', d.a[i]})
+	}
+}
+
+// diff appends the changes turning a[a0:a1] into b[b0:b1]
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	// the common prefix and suffix are unchanged, usually most of the lines
+	prefix := 0
+	for a0+prefix < a1 && b0+prefix < b1 && d.ai[a0+prefix] == d.bi[b0+prefix] {
+		prefix++
+	}
+	suffix := 0
+	for a1-suffix > a0+prefix && b1-suffix > b0+prefix && d.ai[a1-1-suffix] == d.bi[b1-1-suffix] {
+		suffix++
+	}
+	d.same(a0, a0+prefix)
+	defer d.same(a1-suffix, a1)
+	a0, b0 = a0+prefix, b0+prefix
+	a1, b1 = a1-suffix, b1-suffix
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.lines = append(d.lines, diffLine{'+', d.b[j]})
+		}
+		return
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.lines = append(d.lines, diffLine{'-', d.a[i]})
+		}
+		return
+	case a1-a0 == 1:
+		for j := b0; j < b1; j++ {
+			if d.bi[j] == d.ai[a0] {
+				d.diff(a0, a0, b0, j)
+				d.same(a0, a1)
+				d.diff(a1, a1, j+1, b1)
+				return
+			}
+		}
+		d.diff(a0, a1, b0, b0)
+		d.diff(a1, a1, b0, b1)
+		return
+	}
+	// split a in halves and b where the lcs of the upper half and of the lower half add up to the most
+	mid := (a0 + a1) / 2
+	upper := d.lcsLengths(a0, mid, b0, b1, false)
+	lower := d.lcsLengths(mid, a1, b0, b1, true)
+	split, best := 0, -1
+	for k := 0; k <= b1-b0; k++ {
+		if l := upper[k] + lower[b1-b0-k]; l > best {
+			split, best = k, l
+		}
+	}
+	d.diff(a0, mid, b0, b0+split)
+	d.diff(mid, a1, b0+split, b1)
+}
+
+// lcsLengths returns the lcs length of a[a0:a1] with every prefix b[b0:b0+k] of b[b0:b1] at index k,
+// or with every suffix of length k when reverse is set, in two rows of space
+func (d *differ) lcsLengths(a0, a1, b0, b1 int, reverse bool) []int {
+	m := b1 - b0
+	prev, cur := make([]int, m+1), make([]int, m+1)
+	for i := 0; i < a1-a0; i++ {
+		ai := d.ai[a0+i]
+		if reverse {
+			ai = d.ai[a1-1-i]
+		}
+		for k := 1; k <= m; k++ {
+			bj := d.bi[b0+k-1]
+			if reverse {
+				bj = d.bi[b1-k]
+			}
+			if ai == bj {
+				cur[k] = prev[k-1] + 1
+			} else {
+				cur[k] = max(prev[k], cur[k-1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// split turns "a b c" into lines, "" into no lines
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, " ")
+}
+
+// ops renders the op of every line, e.g. " -+ "
+func ops(lines []diffLine) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteByte(l.op)
+	}
+	return sb.String()
+}
+
+// lcsLength the length of the longest common subsequence, with the full table
+func lcsLength(a, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				table[i][j] = table[i-1][j-1] + 1
+			} else {
+				table[i][j] = max(table[i-1][j], table[i][j-1])
+			}
+		}
+	}
+	return table[len(a)][len(b)]
+}
+
+// checkDiffLines verifies lines turn a into b in order and keep a longest common subsequence
+func checkDiffLines(t *testing.T, a, b []string, lines []diffLine) {
+	t.Helper()
+	var gotA, gotB []string
+	same := 0
+	for _, l := range lines {
+		if l.op != '+' {
+			gotA = append(gotA, l.text)
+		}
+		if l.op != '-' {
+			gotB = append(gotB, l.text)
+		}
+		if l.op == ' ' {
+			same++
+		}
+	}
+	if strings.Join(gotA, "\n") != strings.Join(a, "\n") || len(gotA) != len(a) {
+		t.Fatalf("old side: got %q, want %q", gotA, a)
+	}
+	if strings.Join(gotB, "\n") != strings.Join(b, "\n") || len(gotB) != len(b) {
+		t.Fatalf("new side: got %q, want %q", gotB, b)
+	}
+	if want := lcsLength(a, b); same != want {
+		t.Fatalf("got %d unchanged lines, want the lcs length %d", same, want)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"both empty", "", "", ""},
+		{"old empty", "", "x y", "++"},
+		{"new empty", "x y", "", "--"},
+		{"identical", "a b c", "a b c", "   "},
+		{"all different", "a b", "c d", "--++"},
+		{"insert in the middle", "a b c", "a b x c", "  + "},
+		{"delete in the middle", "a b x c", "a b c", "  - "},
+		{"replace one line", "a b c", "a x c", " -+ "},
+		{"append", "a b", "a b c d", "  ++"},
+		{"prepend", "c d", "a b c d", "++  "},
+		{"repeated lines", "a a b a", "a b a a", " - + "},
+		{"moved line", "a b c d", "b c d a", "-   +"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := split(tt.a), split(tt.b)
+			lines := diffLines(a, b)
+			checkDiffLines(t, a, b, lines)
+			if got := ops(lines); got != tt.want {
+				t.Errorf("got ops %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffLinesRandom compares the linear space diff with the lcs of the full table
+func TestDiffLinesRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := func() []string {
+		lines := make([]string, r.IntN(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.IntN(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		checkDiffLines(t, a, b, diffLines(a, b))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb", "a\nb", ""},
+		{"both empty", "", "", ""},
+		{"old empty", "", "a", "--- old\n+++ new\n@@ -1,1 +1,1 @@\n-\n+a\n"},
+		{"one change with context", "1\n2\n3\n4\n5\n6\n7\n8\n9", "1\n2\n3\n4\nx\n6\n7\n8\n9",
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n"},
+		{"close changes share a hunk", "1\n2\n3\n4\n5\n6\n7", "x\n2\n3\n4\n5\n6\ny",
+			"--- old\n+++ new\n@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n"},
+		{"distant changes get two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10", "x\n2\n3\n4\n5\n6\n7\n8\n9\ny",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n"},
+		{"insert only", "1\n2", "1\nx\n2", "--- old\n+++ new\n@@ -1,2 +1,3 @@\n 1\n+x\n 2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff("old", "new", tt.a, tt.b); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
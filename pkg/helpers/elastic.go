@@ -8,9 +8,12 @@ package helpers
 
 import (
 	"crypto/tls"
+	"encoding/json"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,8 +23,8 @@ import (
 	"k8s.io/klog"
 )
 
-// NewElasticSearchClient create elasticsearch.Client
-func NewElasticSearchClient(host string, insecureSkipVerify bool) (*elasticsearch.Client, error) {
+// NewElasticSearchClient create elasticsearch.Client, extra options such as credentials are appended
+func NewElasticSearchClient(host string, insecureSkipVerify bool, extra ...elasticsearch.Option) (*elasticsearch.Client, error) {
 	options := []elasticsearch.Option{
 		elasticsearch.WithAddresses(host),
 	}
@@ -53,13 +56,102 @@ func NewElasticSearchClient(host string, insecureSkipVerify bool) (*elasticsearc
 			},
 		))
 	}
+	options = append(options, extra...)
 	client, err := elasticsearch.New(options...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "host=%s", host)
 	}
+	client.Transport = &productHeaderTransport{Interface: client.Transport}
 	return client, nil
 }
 
+// WithoutTransportRetry disables the retries of the transport, for a client whose requests are retried by the caller:
+// the bulk requests of a load are retried with their own backoff up to --max-retries
+func WithoutTransportRetry() elasticsearch.Option {
+	return elasticsearch.WithTransportOptions(elastictransport.WithDisableRetry())
+}
+
+// productHeaderTransport marks every response as coming from elasticsearch: the client rejects responses without
+// the X-Elastic-Product header, which elasticsearch before 7.14 and opensearch do not send, but they are
+// the clusters a dump is migrated from and, with typed bulk metadata, loaded into
+type productHeaderTransport struct {
+	elastictransport.Interface
+}
+
+func (t *productHeaderTransport) Perform(req *http.Request) (*http.Response, error) {
+	res, err := t.Interface.Perform(req)
+	if err == nil && res.Header.Get("X-Elastic-Product") == "" {
+		res.Header.Set("X-Elastic-Product", "Elasticsearch")
+	}
+	return res, err
+}
+
+// ResolveIndices expands a comma separated list of index names and wildcard patterns into sorted concrete index names,
+// matched aliases and data streams are expanded to the indices behind them
+func ResolveIndices(client *elasticsearch.Client, pattern string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(pattern, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("empty index pattern")
+	}
+	res, err := client.Indices.ResolveIndex(names)
+	if err != nil {
+		return nil, errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if res.IsError() || err != nil {
+		return nil, errors.Errorf("resolve index: %s failed, status: %d, body: %s", pattern, res.StatusCode, string(body))
+	}
+	var resolved struct {
+		Indices []struct {
+			Name string `json:"name"`
+		} `json:"indices"`
+		Aliases []struct {
+			Name    string   `json:"name"`
+			Indices []string `json:"indices"`
+		} `json:"aliases"`
+		DataStreams []struct {
+			Name           string   `json:"name"`
+			BackingIndices []string `json:"backing_indices"`
+		} `json:"data_streams"`
+	}
+	err = json.Unmarshal(body, &resolved)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	seen := map[string]bool{}
+	indices := make([]string, 0, len(resolved.Indices))
+	add := func(index string) {
+		if !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+	for _, index := range resolved.Indices {
+		add(index.Name)
+	}
+	for _, alias := range resolved.Aliases {
+		klog.V(4).Infof("alias: %s resolved to indices: %v\n", alias.Name, alias.Indices)
+		for _, index := range alias.Indices {
+			add(index)
+		}
+	}
+	for _, stream := range resolved.DataStreams {
+		klog.V(4).Infof("data stream: %s resolved to backing indices: %v\n", stream.Name, stream.BackingIndices)
+		for _, index := range stream.BackingIndices {
+			add(index)
+		}
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
 func PathJoin(a, b string) string {
 	if strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/") {
 		return a + b[1:]
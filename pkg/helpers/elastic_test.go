@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResolveIndices(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"indices": [
+				{"name": "logs-old", "attributes": ["open"]},
+				{"name": "logs-2024", "aliases": ["logs-current"], "attributes": ["open"]}
+			],
+			"aliases": [
+				{"name": "logs-current", "indices": ["logs-2024", "archive-2023"]}
+			],
+			"data_streams": [
+				{"name": "logs-app", "backing_indices": [".ds-logs-app-000002", ".ds-logs-app-000001"], "timestamp_field": "@timestamp"}
+			]
+		}`))
+	}))
+	defer srv.Close()
+	client, err := NewElasticSearchClient(srv.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ResolveIndices(client, "logs-*, archive-2023")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/_resolve/index/logs-*,archive-2023" {
+		t.Errorf("got path %s", path)
+	}
+	// indices behind aliases and data streams are included once
+	want := []string{".ds-logs-app-000001", ".ds-logs-app-000002", "archive-2023", "logs-2024", "logs-old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResolveIndicesEmptyPattern(t *testing.T) {
+	_, err := ResolveIndices(nil, " , ")
+	if err == nil {
+		t.Fatal("empty pattern must fail")
+	}
+}
@@ -0,0 +1,27 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFileAtomic replaces file with data through a temporary file, a crash never leaves a partial file
+func WriteFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write file: %s failed", tmp)
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return errors.Wrapf(err, "rename file: %s failed", tmp)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package helpers
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/pkg/errors"
+)
+
+// ClusterVersion version of an elasticsearch cluster
+type ClusterVersion struct {
+	Number       string `json:"number"`
+	Distribution string `json:"distribution"`
+	Major        int    `json:"major"`
+	Minor        int    `json:"minor"`
+}
+
+// ParseVersion parses a version number such as 7.17.3 or 8.0.0-SNAPSHOT
+func ParseVersion(number string) (*ClusterVersion, error) {
+	v := &ClusterVersion{Number: number}
+	parts := strings.SplitN(number, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, errors.Errorf("invalid version: %s", number)
+	}
+	v.Major = major
+	if len(parts) > 1 {
+		v.Minor, _ = strconv.Atoi(parts[1])
+	}
+	return v, nil
+}
+
+// DistributionOpenSearch distribution reported by the info api of opensearch
+const DistributionOpenSearch = "opensearch"
+
+// GetClusterVersion asks the cluster for its version with the info api, it needs the monitor cluster privilege.
+// Opensearch forked from elasticsearch 7.10 and is reported as that version, its own number stays in Number
+func GetClusterVersion(client *elasticsearch.Client) (*ClusterVersion, error) {
+	res, err := client.Info()
+	if err != nil {
+		return nil, errors.Cause(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if res.IsError() || err != nil {
+		return nil, errors.Errorf("get cluster info failed, status: %d, body: %s", res.StatusCode, string(body))
+	}
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	v, err := ParseVersion(info.Version.Number)
+	if err != nil {
+		return nil, err
+	}
+	v.Distribution = info.Version.Distribution
+	if v.Distribution == DistributionOpenSearch {
+		v.Major, v.Minor = 7, 10
+	}
+	return v, nil
+}
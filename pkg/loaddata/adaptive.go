@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// batchSizer adapts the number of documents per bulk request shared by all workers:
+// the size grows while full batches finish well under the target latency,
+// and shrinks when a bulk is slower than the target or rejected by an overloaded cluster
+type batchSizer struct {
+	mu      sync.Mutex
+	size    int
+	min     int
+	max     int
+	latency time.Duration
+}
+
+func newBatchSizer(initial, minSize, maxSize int, latency time.Duration) *batchSizer {
+	minSize = max(minSize, 1)
+	maxSize = max(maxSize, minSize)
+	return &batchSizer{
+		size:    min(max(initial, minSize), maxSize),
+		min:     minSize,
+		max:     maxSize,
+		latency: latency,
+	}
+}
+
+// current returns the batch size to pop next
+func (b *batchSizer) current() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// observe records a bulk of n documents accepted after latency
+func (b *batchSizer) observe(n int, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case latency > b.latency:
+		b.resize(b.size*3/4, "slow bulk: %v", latency)
+	case latency < b.latency/2 && n >= b.size:
+		// only a full batch tells the cluster could take more
+		b.resize(b.size+b.size/4+1, "fast bulk: %v", latency)
+	}
+}
+
+// reject records a bulk request or items rejected with 429 or 5xx
+func (b *batchSizer) reject(status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resize(b.size/2, "rejected: %d", status)
+}
+
+// timeout records a bulk request timed out before the cluster answered
+func (b *batchSizer) timeout(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resize(b.size/2, "timed out: %v", err)
+}
+
+// resize must be called with lock held
+func (b *batchSizer) resize(size int, format string, args ...interface{}) {
+	size = min(max(size, b.min), b.max)
+	if size == b.size {
+		return
+	}
+	args = append([]interface{}{b.size, size}, args...)
+	klog.Infof("adaptive batch size: %d -> %d, "+format+"\n", args...)
+	b.size = size
+}
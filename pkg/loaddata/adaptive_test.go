@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9"
+)
+
+func TestBatchSizer(t *testing.T) {
+	b := newBatchSizer(100, 10, 200, time.Second)
+	// a partial batch does not grow the size however fast it is
+	b.observe(50, 10*time.Millisecond)
+	if got := b.current(); got != 100 {
+		t.Fatalf("after partial batch: got %d, want 100", got)
+	}
+	b.observe(100, 10*time.Millisecond)
+	if got := b.current(); got != 126 {
+		t.Fatalf("after fast batch: got %d, want 126", got)
+	}
+	for i := 0; i < 10; i++ {
+		b.observe(b.current(), 10*time.Millisecond)
+	}
+	if got := b.current(); got != 200 {
+		t.Fatalf("grows up to max: got %d, want 200", got)
+	}
+	b.observe(200, 2*time.Second)
+	if got := b.current(); got != 150 {
+		t.Fatalf("after slow batch: got %d, want 150", got)
+	}
+	for i := 0; i < 10; i++ {
+		b.reject(http.StatusTooManyRequests)
+	}
+	if got := b.current(); got != 10 {
+		t.Fatalf("shrinks down to min: got %d, want 10", got)
+	}
+}
+
+// TestBulkTimeoutShrinksBatch a bulk not answered within BulkTimeoutSec is retried and the batch shrinks
+func TestBulkTimeoutShrinksBatch(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		sizes []int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" {
+			_, _ = w.Write([]byte(`{"version":{"number":"8.15.0"}}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		n := bytes.Count(body, []byte("\n")) / 2
+		mu.Lock()
+		sizes = append(sizes, n)
+		first := len(sizes) == 1
+		mu.Unlock()
+		if first {
+			// answer after the client gave up
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		items := strings.TrimSuffix(strings.Repeat(`{"index":{"status":201}},`, n), ",")
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[` + items + `]}`))
+	}))
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewDataQueue[*Hit]()
+	queue.Push(awkwardHits...)
+	queue.Push(awkwardHits...)
+	queue.Stop()
+	opt := NewLoadDataOption()
+	opt.Action = ActionIndex
+	opt.Index = "target"
+	opt.Batch = len(awkwardHits)
+	opt.MinBatch = 1
+	opt.Adaptive = true
+	opt.RetryBackoffMs = 1
+	opt.BulkTimeoutSec = 1
+	err = LoadData(client, queue, opt)
+	if err != nil {
+		t.Fatalf("timeout not retried: %v", err)
+	}
+	// the timed out batch is resent as is, the following ones start from half the size
+	if len(sizes) < 3 || sizes[0] != len(awkwardHits) || sizes[1] != len(awkwardHits) || sizes[2] >= len(awkwardHits) {
+		t.Errorf("got bulk sizes %v, want a resent batch of %d then smaller ones", sizes, len(awkwardHits))
+	}
+	if !isTimeout(context.DeadlineExceeded) || isTimeout(context.Canceled) {
+		t.Error("isTimeout: deadline exceeded is a timeout, cancel is not")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+type AckFunc func(line int64) error
+
+// ackTracker turns batches acknowledged out of order by concurrent workers into
+// the highest input line below which every hit was acknowledged
+type ackTracker struct {
+	mu    sync.Mutex
+	onAck AckFunc
+	// seq of the last hit of the contiguous acknowledged prefix
+	seq int64
+	// done acknowledged batches beyond the prefix, keyed by the seq of their first hit
+	done map[int64]*Hit
+}
+
+func newAckTracker(onAck AckFunc) *ackTracker {
+	return &ackTracker{
+		onAck: onAck,
+		done:  map[int64]*Hit{},
+	}
+}
+
+// ack records a batch popped from the queue, its hits have consecutive seq
+func (t *ackTracker) ack(hits []*Hit) error {
+	first, last := hits[0], hits[len(hits)-1]
+	if first.seq <= 0 {
+		return errors.New("acknowledge requires hits read by LoadHits")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last.seq <= t.seq {
+		// already part of the acknowledged prefix
+		return nil
+	}
+	t.done[first.seq] = last
+	var line int64
+	for {
+		next, ok := t.done[t.seq+1]
+		if !ok {
+			break
+		}
+		delete(t.done, t.seq+1)
+		t.seq = next.seq
+		line = next.Line
+	}
+	if line == 0 {
+		return nil
+	}
+	return t.onAck(line)
+}
+
+// Checkpoint the saved progress of a load: lines of File up to Line were acknowledged
+type Checkpoint struct {
+	File      string    `json:"file"`
+	Line      int64     `json:"line"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read checkpoint: %s failed", file)
+	}
+	cp := &Checkpoint{}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse checkpoint: %s failed", file)
+	}
+	return cp, nil
+}
+
+func SaveCheckpoint(file string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return helpers.WriteFileAtomic(file, data)
+}
+
+// Checkpointer saves the acknowledged line of inputFile at most once per interval
+type Checkpointer struct {
+	mu        sync.Mutex
+	file      string
+	inputFile string
+	interval  time.Duration
+	line      int64
+	saved     time.Time
+}
+
+func NewCheckpointer(file, inputFile string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		file:      file,
+		inputFile: inputFile,
+		interval:  interval,
+		saved:     time.Now(),
+	}
+}
+
+// OnAck is an AckFunc
+func (c *Checkpointer) OnAck(line int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.line = line
+	if time.Since(c.saved) < c.interval {
+		return nil
+	}
+	return c.save()
+}
+
+// Save writes the last acknowledged line now
+func (c *Checkpointer) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+func (c *Checkpointer) save() error {
+	if c.line == 0 {
+		return nil
+	}
+	cp := &Checkpoint{
+		File:      c.inputFile,
+		Line:      c.line,
+		UpdatedAt: time.Now(),
+	}
+	err := SaveCheckpoint(c.file, cp)
+	if err != nil {
+		return err
+	}
+	c.saved = cp.UpdatedAt
+	klog.V(4).Infof("checkpoint saved, line: %d, file: %s\n", cp.Line, c.file)
+	return nil
+}
+
+// Remove deletes the checkpoint of a finished load
+func (c *Checkpointer) Remove() error {
+	err := os.Remove(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"testing"
+)
+
+// batch returns the hits with seq first..last, as LoadHits numbers them; the input has an empty line
+// after every tenth hit, so lines drift away from seqs
+func batch(first, last int64) []*Hit {
+	hits := make([]*Hit, 0, last-first+1)
+	for seq := first; seq <= last; seq++ {
+		hits = append(hits, &Hit{seq: seq, Line: seq + (seq-1)/10})
+	}
+	return hits
+}
+
+func TestAckTracker(t *testing.T) {
+	type step struct {
+		first, last int64
+		// want the line reported by this ack, 0 when nothing is reported
+		want int64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"in order", []step{
+			{1, 10, 10},
+			{11, 20, 21},
+			{21, 25, 27},
+		}},
+		{"out of order", []step{
+			{11, 20, 0},
+			{21, 30, 0},
+			{1, 10, 32},
+			{31, 35, 38},
+		}},
+		{"gap holds the prefix", []step{
+			{1, 10, 10},
+			{21, 30, 0},
+			{31, 40, 0},
+			{41, 45, 0},
+			{11, 20, 49},
+		}},
+		{"several gaps", []step{
+			{31, 40, 0},
+			{11, 20, 0},
+			{1, 10, 21},
+			{41, 50, 0},
+			{21, 30, 54},
+		}},
+		{"duplicate in the prefix", []step{
+			{1, 10, 10},
+			{11, 20, 21},
+			{1, 10, 0},
+			{21, 30, 32},
+		}},
+		{"duplicate beyond the prefix", []step{
+			{11, 20, 0},
+			{11, 20, 0},
+			{1, 10, 21},
+			{21, 21, 23},
+		}},
+		{"single hits", []step{
+			{2, 2, 0},
+			{3, 3, 0},
+			{1, 1, 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			tracker := newAckTracker(func(line int64) error {
+				got = line
+				return nil
+			})
+			for i, s := range tt.steps {
+				got = 0
+				err := tracker.ack(batch(s.first, s.last))
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got != s.want {
+					t.Fatalf("step %d: ack %d..%d reported line %d, want %d", i, s.first, s.last, got, s.want)
+				}
+			}
+			if len(tracker.done) != 0 {
+				t.Errorf("batches left beyond the prefix: %d", len(tracker.done))
+			}
+		})
+	}
+}
+
+func TestAckTrackerRequiresSeq(t *testing.T) {
+	tracker := newAckTracker(func(line int64) error { return nil })
+	err := tracker.ack([]*Hit{{Line: 1}})
+	if err == nil {
+		t.Fatal("hits without seq must be rejected")
+	}
+}
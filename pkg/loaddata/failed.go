@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FailedHit a hit rejected by elasticsearch together with the bulk item error,
+// it is a valid line for LoadHits so the rejects can be fixed and replayed
+type FailedHit struct {
+	Hit
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+type WriteFailedFunc func(hit *Hit, status int, reason json.RawMessage) error
+
+// LazyFailedWriter writes failed hits as ndjson, the file is only created on the first failure
+type LazyFailedWriter struct {
+	mu         sync.Mutex
+	outputFile string
+	file       *os.File
+}
+
+func NewLazyFailedWriter(outputFile string) *LazyFailedWriter {
+	return &LazyFailedWriter{
+		outputFile: outputFile,
+	}
+}
+
+func (w *LazyFailedWriter) Write(hit *Hit, status int, reason json.RawMessage) error {
+	data, err := json.Marshal(&FailedHit{Hit: *hit, Status: status, Error: reason})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data = append(data, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		f, err := os.Create(w.outputFile)
+		if err != nil {
+			return errors.Cause(err)
+		}
+		w.file = f
+	}
+	_, err = w.file.Write(data)
+	if err != nil {
+		return errors.Cause(err)
+	}
+	return nil
+}
+
+func (w *LazyFailedWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		f := w.file
+		w.file = nil
+		return f.Close()
+	}
+	return nil
+}
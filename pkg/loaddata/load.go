@@ -9,30 +9,50 @@ package loaddata
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/shinexia/elasticdump/pkg/helpers"
+	"github.com/shinexia/elasticdump/pkg/metrics"
+	"github.com/shinexia/elasticdump/pkg/progress"
+	"github.com/shinexia/elasticdump/pkg/ratelimit"
+
 	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/esapi"
 	"github.com/pkg/errors"
 	"k8s.io/klog"
 )
 
 func LoadHits(queue *DataQueue[*Hit], in io.Reader, maxLineLength int) error {
+	return LoadHitsFrom(queue, in, maxLineLength, 0)
+}
+
+// LoadHitsFrom is LoadHits skipping the first skipLines lines of in
+func LoadHitsFrom(queue *DataQueue[*Hit], in io.Reader, maxLineLength int, skipLines int64) error {
 	scanner := bufio.NewScanner(in)
 	scanner.Buffer(make([]byte, maxLineLength), maxLineLength)
 	scanner.Split(bufio.ScanLines)
+	lineNo := int64(0)
+	seq := int64(0)
 	for scanner.Scan() {
+		lineNo++
+		if lineNo <= skipLines {
+			continue
+		}
 		line := scanner.Bytes()
 		if len(line) == 0 {
 			continue
 		}
-		hit := &Hit{}
+		seq++
+		hit := &Hit{Line: lineNo, seq: seq}
 		err := json.Unmarshal(line, hit)
 		if err != nil {
-			return errors.WithStack(err)
+			return errors.WithMessagef(err, "line: %d", lineNo)
 		}
 		ok := queue.Push(hit)
 		if !ok {
@@ -45,6 +65,25 @@ func LoadHits(queue *DataQueue[*Hit], in io.Reader, maxLineLength int) error {
 	return nil
 }
 
+// PushRawHits parses hits as returned by a search and pushes them to queue,
+// it fails once the queue was stopped by its consumer
+func PushRawHits(queue *DataQueue[*Hit], hits []json.RawMessage) (int, error) {
+	items := make([]*Hit, len(hits))
+	for i, raw := range hits {
+		hit := &Hit{}
+		err := json.Unmarshal(raw, hit)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		items[i] = hit
+	}
+	ok := queue.Push(items...)
+	if !ok {
+		return 0, errors.New("queue stopped")
+	}
+	return len(items), nil
+}
+
 func GenTestHits(queue *DataQueue[*Hit], epoch, batch int) error {
 	type TestData struct {
 		Content   string `json:"content"`
@@ -81,59 +120,472 @@ func GenTestHits(queue *DataQueue[*Hit], epoch, batch int) error {
 	return nil
 }
 
-func LoadData(client *elasticsearch.Client, queue *DataQueue[*Hit], batch int, index string) error {
+const (
+	// ActionIndex creates or overwrites documents
+	ActionIndex = "index"
+	// ActionCreate creates documents, existing ones are rejected with 409
+	ActionCreate = "create"
+	// ActionUpdate partially updates existing documents with the source
+	ActionUpdate = "update"
+	// ActionUpsert updates documents with doc_as_upsert, missing ones are created
+	ActionUpsert = "upsert"
+	// ActionDelete deletes documents by _id, the source is ignored
+	ActionDelete = "delete"
+)
+
+const (
+	// VersionTypeExternal indexes a document only if its dumped _version is greater than the stored one
+	VersionTypeExternal = "external"
+	// VersionTypeExternalGTE indexes a document if its dumped _version is greater than or equal to the stored one
+	VersionTypeExternalGTE = "external_gte"
+)
+
+// DefaultDocType the mapping type of documents without one loaded into a typed cluster, the single type of 6.x indexes
+const DefaultDocType = "_doc"
+
+// fallbackVersion assumed when the version of the cluster can not be detected, its bulk metadata is typeless
+const fallbackVersion = 7
+
+type LoadDataOption struct {
+	Index string
+	// Action bulk action of every document: ActionIndex, ActionCreate, ActionUpdate, ActionUpsert or ActionDelete
+	Action string
+	// Batch max number of documents in one bulk request
+	Batch int
+	// BatchBytes max size of one bulk request, used together with Batch, <= 0 is unlimited
+	BatchBytes int64
+	// Adaptive starts with Batch documents per bulk and adapts it between MinBatch and MaxBatch:
+	// growing while bulks finish under TargetLatencyMs and shrinking when slower or rejected
+	Adaptive        bool
+	MinBatch        int
+	MaxBatch        int
+	TargetLatencyMs int
+	// Limiter throttles the bulk requests, optional
+	Limiter *ratelimit.Limiter `json:"-"`
+	// Workers number of concurrent bulk senders pulling from the queue
+	Workers int
+	// MaxRetries max retries of a bulk request or item rejected with 429/5xx
+	MaxRetries int
+	// RetryBackoffMs initial wait before a retry, doubled on each attempt
+	RetryBackoffMs int
+	// MaxRetryBackoffMs upper bound of the wait before a retry
+	MaxRetryBackoffMs int
+	// BulkTimeoutSec gives up on a bulk request not answered in time and retries it with a smaller batch,
+	// 0 waits forever
+	BulkTimeoutSec int
+	// Pipeline ingest pipeline every bulk request is sent through, optional
+	Pipeline string
+	// Version major elasticsearch version of the target cluster, it chooses between typed (before 7) and
+	// typeless bulk metadata, 0 detects it with the info api when LoadData starts
+	Version int
+	// DocType mapping type of hits without one when the target is typed
+	DocType string
+	// VersionType VersionTypeExternal or VersionTypeExternalGTE sends the dumped _version of every hit,
+	// so a restore can be repeated and does not overwrite newer documents, empty disables it
+	VersionType string
+	// KeepIndex loads every hit into the _index it was dumped from instead of Index
+	KeepIndex bool
+	// OnFailed receives every document that failed to index, optional
+	OnFailed WriteFailedFunc `json:"-"`
+	// OnAck receives the input line up to which all hits were acknowledged, optional,
+	// it requires hits read by LoadHits
+	OnAck AckFunc `json:"-"`
+	// Progress counts the indexed and failed documents, optional
+	Progress *progress.Progress `json:"-"`
+	// Metrics counts the documents, retries and bulk latency, optional
+	Metrics *metrics.Stage `json:"-"`
+
+	// sizer set by LoadData in Adaptive mode
+	sizer *batchSizer
+}
+
+func NewLoadDataOption() *LoadDataOption {
+	return &LoadDataOption{
+		Action:            ActionCreate,
+		Batch:             1000,
+		BatchBytes:        10 * 1024 * 1024,
+		MinBatch:          10,
+		MaxBatch:          10000,
+		TargetLatencyMs:   2000,
+		Workers:           1,
+		MaxRetries:        5,
+		RetryBackoffMs:    500,
+		MaxRetryBackoffMs: 30000,
+		BulkTimeoutSec:    0,
+		DocType:           DefaultDocType,
+	}
+}
+
+// loadStats aggregates the counters of all workers
+type loadStats struct {
+	mu      sync.Mutex
+	succeed int
+	failed  int
+	acks    *ackTracker
+}
+
+// add accumulates the result of one bulk request and returns the totals
+func (s *loadStats) add(succeed, failed int) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.succeed += succeed
+	s.failed += failed
+	return s.succeed, s.failed
+}
+
+func LoadData(client *elasticsearch.Client, queue *DataQueue[*Hit], loadOption *LoadDataOption) error {
+	switch loadOption.Action {
+	case ActionIndex, ActionCreate, ActionUpdate, ActionUpsert, ActionDelete:
+	default:
+		return errors.Errorf("unknown bulk action: %s", loadOption.Action)
+	}
+	switch loadOption.VersionType {
+	case "":
+	case VersionTypeExternal, VersionTypeExternalGTE:
+		if loadOption.Action != ActionIndex && loadOption.Action != ActionDelete {
+			return errors.Errorf("version type: %s only works with the index and delete actions", loadOption.VersionType)
+		}
+	default:
+		return errors.Errorf("unknown version type: %s, should be one of: external, external_gte", loadOption.VersionType)
+	}
+	opt := *loadOption
+	loadOption = &opt
+	if loadOption.Version == 0 {
+		v, err := helpers.GetClusterVersion(client)
+		if err != nil {
+			// the info api needs the monitor privilege, a user allowed to write may still load
+			klog.Warningf("detect cluster version failed: %v, send typeless bulk metadata, set --target-version for a cluster before 7\n", err)
+			loadOption.Version = fallbackVersion
+		} else {
+			loadOption.Version = v.Major
+			klog.V(4).Infof("cluster version: %s, typed bulk metadata: %v\n", v.Number, loadOption.typed())
+		}
+	}
+	if loadOption.Adaptive {
+		latency := time.Duration(loadOption.TargetLatencyMs) * time.Millisecond
+		loadOption.sizer = newBatchSizer(loadOption.Batch, loadOption.MinBatch, loadOption.MaxBatch, latency)
+	}
 	startTime := time.Now()
-	// pop and send records to elasticsearch
-	totalSecceed := 0
-	totalError := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stats := &loadStats{}
+	if loadOption.OnAck != nil {
+		stats.acks = newAckTracker(loadOption.OnAck)
+	}
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < max(loadOption.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := loadWorker(ctx, client, queue, loadOption, stats)
+			if err != nil {
+				// stop the other workers and the producer
+				once.Do(func() {
+					firstErr = err
+					cancel()
+					queue.Stop()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	cost := time.Since(startTime).Seconds()
+	klog.Infof("load data succeed, indexed: %d, failed: %v, index: %s, cost: %.3fs\n", stats.succeed, stats.failed, loadOption.Index, cost)
+	return nil
+}
+
+// loadWorker pops batches from the queue and sends them until the queue is drained or ctx is canceled
+func loadWorker(ctx context.Context, client *elasticsearch.Client, queue *DataQueue[*Hit], loadOption *LoadDataOption, stats *loadStats) error {
 	for {
-		hits := queue.Pop(batch)
-		if len(hits) == 0 {
-			break
+		hits := queue.PopBytes(loadOption.batch(), loadOption.BatchBytes, bulkSize)
+		if len(hits) == 0 || ctx.Err() != nil {
+			return nil
+		}
+		if len(hits) == 1 && loadOption.BatchBytes > 0 && int64(bulkSize(hits[0])) > loadOption.BatchBytes {
+			klog.Warningf("document: %s of %d bytes exceeds batch bytes: %d, sent alone\n", hits[0].ID, bulkSize(hits[0]), loadOption.BatchBytes)
 		}
 		klog.V(5).Infof("received lines: %v\n", len(hits))
-		startTime2 := time.Now()
-		var buf bytes.Buffer
-		for _, r := range hits {
-			meta := []byte(fmt.Sprintf(`{"create": {"_index": "%s", "_type": "_doc", "_id": "%s"}}%s`, index, r.ID, "\n"))
-			// have routing field
-			if len(r.Routing) > 0 {
-				meta = []byte(fmt.Sprintf(`{"create": {"_index": "%s", "_type": "_doc", "_id": "%s", "routing": "%s"}}%s`, index, r.ID, r.Routing, "\n"))
-			}
-			buf.Write(meta)
-			buf.Write(r.Source)
-			buf.Write([]byte("\n"))
-		}
-		res, err := client.Bulk(bytes.NewReader(buf.Bytes()))
+		loadOption.Metrics.AddRead(len(hits))
+		startTime := time.Now()
+		succeedCount, errorCount, err := bulkHits(ctx, client, loadOption, hits)
 		if err != nil {
-			return errors.WithStack(err)
+			return err
+		}
+		totalSucceed, totalError := stats.add(succeedCount, errorCount)
+		if loadOption.Progress != nil {
+			size := 0
+			for _, hit := range hits {
+				size += len(hit.Source)
+			}
+			loadOption.Progress.Add(succeedCount, size)
+			loadOption.Progress.Fail(errorCount)
+		}
+		loadOption.Metrics.AddWritten(succeedCount)
+		loadOption.Metrics.AddFailed(errorCount)
+		if stats.acks != nil {
+			err = stats.acks.ack(hits)
+			if err != nil {
+				return err
+			}
 		}
-		body, err := io.ReadAll(res.Body)
-		if res.IsError() || err != nil {
-			return errors.Errorf("status: %d, body: %s", res.StatusCode, string(body))
+		cost := time.Since(startTime).Seconds()
+		klog.Infof("indexed succeed: %v/%v, failed: %v/%v, cost: %.3fs\n", succeedCount, totalSucceed, errorCount, totalError, cost)
+	}
+}
+
+// bulkHits sends hits in bulk requests, retrying rejected requests and items with backoff,
+// and counts the succeed and failed items
+func bulkHits(ctx context.Context, client *elasticsearch.Client, loadOption *LoadDataOption, hits []*Hit) (int, int, error) {
+	succeedCount := 0
+	errorCount := 0
+	pending := hits
+	for attempt := 0; ; attempt++ {
+		canRetry := attempt < loadOption.MaxRetries
+		backoff := retryBackoff(attempt, time.Duration(loadOption.RetryBackoffMs)*time.Millisecond, time.Duration(loadOption.MaxRetryBackoffMs)*time.Millisecond)
+		if loadOption.Limiter != nil {
+			size := 0
+			for _, hit := range pending {
+				size += bulkSize(hit)
+			}
+			err := loadOption.Limiter.Wait(ctx, len(pending), size)
+			if err != nil {
+				return succeedCount, errorCount, err
+			}
 		}
-		var result = &BulkResponse{}
-		err = json.Unmarshal(body, result)
+		bulkStart := time.Now()
+		result, err := sendBulk(ctx, client, loadOption, pending)
+		loadOption.Metrics.ObserveBulk(time.Since(bulkStart))
 		if err != nil {
-			return errors.WithMessagef(err, "status: %d, body: %s", res.StatusCode, string(body))
+			var statusErr *bulkStatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestEntityTooLarge {
+				succeed, failed, err := splitBulk(ctx, client, loadOption, pending, statusErr)
+				return succeedCount + succeed, errorCount + failed, err
+			}
+			if canRetry && ctx.Err() == nil && isTimeout(err) {
+				// an overloaded cluster answers late rather than with 429, retry with a smaller batch
+				klog.Infof("bulk timed out: %v, retry %d/%d in %v\n", err, attempt+1, loadOption.MaxRetries, backoff)
+				loadOption.Metrics.AddRetries(len(pending))
+				if loadOption.sizer != nil {
+					loadOption.sizer.timeout(err)
+				}
+				err = sleepContext(ctx, backoff)
+				if err != nil {
+					return succeedCount, errorCount, err
+				}
+				continue
+			}
+			if !canRetry || !errors.As(err, &statusErr) || !retryableStatus(statusErr.StatusCode) {
+				return succeedCount, errorCount, err
+			}
+			klog.Infof("bulk rejected [%d], retry %d/%d in %v\n", statusErr.StatusCode, attempt+1, loadOption.MaxRetries, backoff)
+			loadOption.Metrics.AddRetries(len(pending))
+			if loadOption.sizer != nil {
+				loadOption.sizer.reject(statusErr.StatusCode)
+			}
+			err = sleepContext(ctx, backoff)
+			if err != nil {
+				return succeedCount, errorCount, err
+			}
+			continue
+		}
+		if len(result.Items) != len(pending) {
+			return succeedCount, errorCount, errors.Errorf("bulk response has %d items, sent %d", len(result.Items), len(pending))
 		}
-		succeedCount := 0
-		errorCount := 0
-		for _, d := range result.Items {
+		var (
+			retry        []*Hit
+			rejectStatus int
+		)
+		for i := range result.Items {
+			d := result.Items[i].Result()
+			if d == nil {
+				return succeedCount, errorCount, errors.Errorf("bulk response item %d without result", i)
+			}
 			// ... so for any HTTP status above 201 ...
-			if d.Create.Status > 201 {
+			if d.Status <= 201 {
+				succeedCount++
+			} else if d.Status == http.StatusConflict && loadOption.VersionType != "" {
+				// the stored document is as new or newer, nothing to restore
+				klog.V(4).Infof("skip document: %s, version conflict: %s\n", pending[i].ID, d.Error)
+				succeedCount++
+			} else if canRetry && retryableStatus(d.Status) {
+				retry = append(retry, pending[i])
+				rejectStatus = d.Status
+			} else {
 				errorCount++
-				klog.Infof("Error [%d]: %s\n", d.Create.Status, d.Create.Error)
+				klog.Infof("Error [%d]: %s\n", d.Status, d.Error)
+				if loadOption.OnFailed != nil {
+					err = loadOption.OnFailed(pending[i], d.Status, d.Error)
+					if err != nil {
+						return succeedCount, errorCount, err
+					}
+				}
+			}
+		}
+		if loadOption.sizer != nil {
+			if len(retry) > 0 {
+				loadOption.sizer.reject(rejectStatus)
 			} else {
-				succeedCount++
+				loadOption.sizer.observe(len(pending), time.Since(bulkStart))
 			}
 		}
-		totalSecceed += succeedCount
-		totalError += errorCount
-		cost2 := time.Since(startTime2).Seconds()
-		klog.Infof("indexed succeed: %v/%v, failed: %v/%v, cost: %.3fs\n", succeedCount, totalSecceed, errorCount, totalError, cost2)
+		if len(retry) == 0 {
+			return succeedCount, errorCount, nil
+		}
+		klog.Infof("%d items rejected, retry %d/%d in %v\n", len(retry), attempt+1, loadOption.MaxRetries, backoff)
+		loadOption.Metrics.AddRetries(len(retry))
+		err = sleepContext(ctx, backoff)
+		if err != nil {
+			return succeedCount, errorCount, err
+		}
+		pending = retry
 	}
-	cost := time.Since(startTime).Seconds()
-	klog.Infof("load data succeed, indexed: %d, failed: %v, index: %s, cost: %.3fs\n", totalSecceed, totalError, index, cost)
+}
+
+// splitBulk resends hits rejected with 413 in two halves, a single document too large for the cluster
+// is failed on its own without failing the load
+func splitBulk(ctx context.Context, client *elasticsearch.Client, loadOption *LoadDataOption, hits []*Hit, statusErr *bulkStatusError) (int, int, error) {
+	if len(hits) == 1 {
+		klog.Warningf("document: %s of %d bytes exceeds http.max_content_length of the cluster\n", hits[0].ID, bulkSize(hits[0]))
+		if loadOption.OnFailed != nil {
+			reason := json.RawMessage(statusErr.Body)
+			if !json.Valid(reason) {
+				reason, _ = json.Marshal(statusErr.Body)
+			}
+			err := loadOption.OnFailed(hits[0], statusErr.StatusCode, reason)
+			if err != nil {
+				return 0, 1, err
+			}
+		}
+		return 0, 1, nil
+	}
+	half := len(hits) / 2
+	klog.Infof("bulk of %d documents too large [%d], split in two\n", len(hits), statusErr.StatusCode)
+	succeed, failed, err := bulkHits(ctx, client, loadOption, hits[:half])
+	if err != nil {
+		return succeed, failed, err
+	}
+	succeed2, failed2, err := bulkHits(ctx, client, loadOption, hits[half:])
+	return succeed + succeed2, failed + failed2, err
+}
+
+// sendBulk sends hits in one bulk request, an error status is returned as *bulkStatusError
+func sendBulk(ctx context.Context, client *elasticsearch.Client, loadOption *LoadDataOption, hits []*Hit) (*BulkResponse, error) {
+	var buf bytes.Buffer
+	for _, r := range hits {
+		err := writeBulkAction(&buf, loadOption, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if loadOption.BulkTimeoutSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(loadOption.BulkTimeoutSec)*time.Second)
+		defer cancel()
+	}
+	o := []func(*esapi.BulkRequest){client.Bulk.WithContext(ctx)}
+	if loadOption.Pipeline != "" {
+		o = append(o, client.Bulk.WithPipeline(loadOption.Pipeline))
+	}
+	res, err := client.Bulk(bytes.NewReader(buf.Bytes()), o...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "status: %d, body: %s", res.StatusCode, string(body))
+	}
+	if res.IsError() {
+		return nil, errors.WithStack(&bulkStatusError{StatusCode: res.StatusCode, Body: string(body)})
+	}
+	var result = &BulkResponse{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "status: %d, body: %s", res.StatusCode, string(body))
+	}
+	return result, nil
+}
+
+// typed reports whether the target cluster needs a _type in bulk metadata
+func (o *LoadDataOption) typed() bool {
+	return o.Version > 0 && o.Version < 7
+}
+
+// docType returns the _type of hit in bulk metadata, empty for a typeless target
+func (o *LoadDataOption) docType(r *Hit) string {
+	if !o.typed() {
+		return ""
+	}
+	if r.Type != "" {
+		return r.Type
+	}
+	if o.DocType != "" {
+		return o.DocType
+	}
+	return DefaultDocType
+}
+
+// batch returns the max number of documents of the next bulk
+func (o *LoadDataOption) batch() int {
+	if o.sizer != nil {
+		return o.sizer.current()
+	}
+	return o.Batch
+}
+
+// index returns the index hit is loaded into
+func (o *LoadDataOption) index(r *Hit) string {
+	if o.KeepIndex && r.Index != "" {
+		return r.Index
+	}
+	return o.Index
+}
+
+// writeBulkAction appends the action line and, except for delete, the document line of hit
+func writeBulkAction(buf *bytes.Buffer, loadOption *LoadDataOption, r *Hit) error {
+	action := loadOption.Action
+	name := action
+	if action == ActionUpsert {
+		name = ActionUpdate
+	}
+	meta := &bulkMeta{
+		Index:   loadOption.index(r),
+		Type:    loadOption.docType(r),
+		ID:      r.ID,
+		Routing: r.Routing,
+	}
+	if loadOption.VersionType != "" && r.Version != nil {
+		meta.Version = r.Version
+		meta.VersionType = loadOption.VersionType
+	}
+	// encoding escapes quotes, backslashes and control characters of ids and routings
+	line, err := json.Marshal(map[string]*bulkMeta{name: meta})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	switch action {
+	case ActionDelete:
+		return nil
+	case ActionUpdate:
+		buf.WriteString(`{"doc": `)
+		buf.Write(r.Source)
+		buf.WriteString(`}`)
+	case ActionUpsert:
+		buf.WriteString(`{"doc": `)
+		buf.Write(r.Source)
+		buf.WriteString(`, "doc_as_upsert": true}`)
+	default:
+		buf.Write(r.Source)
+	}
+	buf.Write([]byte("\n"))
 	return nil
 }
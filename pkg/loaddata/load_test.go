@@ -0,0 +1,344 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shinexia/elasticdump/pkg/dumpdata"
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/elastic/go-elasticsearch/v9"
+)
+
+// awkwardHits ids and routings which break a bulk body built by string interpolation
+var awkwardHits = []*Hit{
+	{ID: "plain", Source: json.RawMessage(`{"n":1}`)},
+	{ID: `quote"id`, Routing: `quote"routing`, Source: json.RawMessage(`{"n":2}`)},
+	{ID: `back\slash`, Routing: `\`, Source: json.RawMessage(`{"n":3}`)},
+	{ID: "new\nline", Routing: "carriage\r\nreturn", Source: json.RawMessage(`{"n":4}`)},
+	{ID: "tab\tand\u0001control", Routing: "\u001f", Source: json.RawMessage(`{"n":5}`)},
+	{ID: "unicode-日本語-😀", Routing: "ünïcödé", Source: json.RawMessage(`{"title":"日本語 \"quoted\"\nline"}`)},
+	{ID: `{"_id": "injected"}`, Routing: `", "_index": "other`, Source: json.RawMessage(`{"n":7}`)},
+	{ID: "html<&>", Source: json.RawMessage(`{"n":8}`)},
+}
+
+type bulkLine struct {
+	action string
+	meta   bulkMeta
+	doc    json.RawMessage
+}
+
+// parseBulk decodes a bulk body line by line, as elasticsearch does
+func parseBulk(t *testing.T, body []byte, action string) []bulkLine {
+	t.Helper()
+	var lines []bulkLine
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var meta map[string]bulkMeta
+		err := json.Unmarshal(scanner.Bytes(), &meta)
+		if err != nil {
+			t.Fatalf("invalid action line: %q: %v", scanner.Text(), err)
+		}
+		if len(meta) != 1 {
+			t.Fatalf("action line: %q has %d actions", scanner.Text(), len(meta))
+		}
+		line := bulkLine{}
+		for name, m := range meta {
+			line.action, line.meta = name, m
+		}
+		if action != ActionDelete {
+			if !scanner.Scan() {
+				t.Fatalf("missing document line after: %q", scanner.Text())
+			}
+			if !json.Valid(scanner.Bytes()) {
+				t.Fatalf("invalid document line: %q", scanner.Text())
+			}
+			line.doc = append(json.RawMessage{}, scanner.Bytes()...)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func writeBulk(t *testing.T, opt *LoadDataOption, hits []*Hit) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, hit := range hits {
+		err := writeBulkAction(&buf, opt, hit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestWriteBulkActionEscapesMetadata(t *testing.T) {
+	for _, action := range []string{ActionIndex, ActionCreate, ActionUpdate, ActionUpsert, ActionDelete} {
+		t.Run(action, func(t *testing.T) {
+			opt := NewLoadDataOption()
+			opt.Action = action
+			opt.Index = `index"with\quote`
+			opt.Version = 8
+			lines := parseBulk(t, writeBulk(t, opt, awkwardHits), action)
+			if len(lines) != len(awkwardHits) {
+				t.Fatalf("got %d actions, want %d", len(lines), len(awkwardHits))
+			}
+			wantName := action
+			if action == ActionUpsert {
+				wantName = ActionUpdate
+			}
+			for i, line := range lines {
+				hit := awkwardHits[i]
+				if line.action != wantName {
+					t.Errorf("action: got %q, want %q", line.action, wantName)
+				}
+				if line.meta.Index != opt.Index || line.meta.ID != hit.ID || line.meta.Routing != hit.Routing {
+					t.Errorf("metadata: got %+v, want index %q, id %q, routing %q", line.meta, opt.Index, hit.ID, hit.Routing)
+				}
+				if line.meta.Type != "" {
+					t.Errorf("typeless target got _type: %q", line.meta.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteBulkActionTypedAndVersioned(t *testing.T) {
+	version := int64(42)
+	hits := []*Hit{
+		{ID: `a"b`, Type: "doc", Version: &version, Source: json.RawMessage(`{}`)},
+		{ID: "c\nd", Source: json.RawMessage(`{}`)},
+	}
+	opt := NewLoadDataOption()
+	opt.Action = ActionIndex
+	opt.Index = "target"
+	opt.Version = 6
+	opt.VersionType = VersionTypeExternal
+	lines := parseBulk(t, writeBulk(t, opt, hits), opt.Action)
+	if got := lines[0].meta; got.Type != "doc" || got.Version == nil || *got.Version != version || got.VersionType != VersionTypeExternal {
+		t.Errorf("versioned hit: got %+v", got)
+	}
+	if got := lines[1].meta; got.Type != DefaultDocType || got.Version != nil || got.VersionType != "" {
+		t.Errorf("hit without type and version: got %+v", got)
+	}
+}
+
+// TestDumpLoadRoundTrip writes hits as dump data does, reads them back with LoadHits and checks
+// the bulk body addresses the same documents
+func TestDumpLoadRoundTrip(t *testing.T) {
+	for _, compression := range []string{helpers.CompressNone, helpers.CompressGzip, helpers.CompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "data.json"+helpers.CompressionExt(compression))
+			raw := make([]json.RawMessage, len(awkwardHits))
+			for i, hit := range awkwardHits {
+				data, err := json.Marshal(hit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw[i] = data
+			}
+			writer := dumpdata.NewLazyDataWriter(file, compression)
+			_, err := writer.Write(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			in, err := helpers.OpenFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			queue := NewDataQueue[*Hit]()
+			err = LoadHits(queue, in, 1024*1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queue.Stop()
+			loaded := queue.Pop(0)
+			if len(loaded) != len(awkwardHits) {
+				t.Fatalf("loaded %d hits, want %d", len(loaded), len(awkwardHits))
+			}
+
+			opt := NewLoadDataOption()
+			opt.Action = ActionIndex
+			opt.Index = "restored"
+			opt.Version = 8
+			lines := parseBulk(t, writeBulk(t, opt, loaded), opt.Action)
+			for i, line := range lines {
+				hit := awkwardHits[i]
+				if line.meta.ID != hit.ID || line.meta.Routing != hit.Routing {
+					t.Errorf("hit %d: got id %q routing %q, want id %q routing %q", i, line.meta.ID, line.meta.Routing, hit.ID, hit.Routing)
+				}
+				if !jsonEqual(t, line.doc, hit.Source) {
+					t.Errorf("hit %d: got source %s, want %s", i, line.doc, hit.Source)
+				}
+			}
+		})
+	}
+}
+
+func jsonEqual(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+// TestLoadDataWithoutInfo loads with credentials lacking the monitor privilege: the info api fails
+// and the load goes on with typeless bulk metadata
+func TestLoadDataWithoutInfo(t *testing.T) {
+	var bulkBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"type":"security_exception","reason":"action [cluster:monitor/main] is unauthorized"},"status":403}`))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bulkBody = body
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewDataQueue[*Hit]()
+	queue.Push(awkwardHits[:2]...)
+	queue.Stop()
+	opt := NewLoadDataOption()
+	opt.Action = ActionIndex
+	opt.Index = "target"
+	err = LoadData(client, queue, opt)
+	if err != nil {
+		t.Fatalf("load failed without the info api: %v", err)
+	}
+	lines := parseBulk(t, bulkBody, opt.Action)
+	if len(lines) != 2 {
+		t.Fatalf("got %d actions, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if line.meta.Type != "" {
+			t.Errorf("got _type: %q, want typeless metadata", line.meta.Type)
+		}
+	}
+}
+
+// TestLoadDataWithoutProductHeader loads into clusters which do not send the X-Elastic-Product header,
+// elasticsearch before 7.14 gets typed bulk metadata and opensearch typeless
+func TestLoadDataWithoutProductHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     string
+		wantType string
+	}{
+		{"elasticsearch 6", `{"version":{"number":"6.8.23"}}`, DefaultDocType},
+		{"elasticsearch 7.10", `{"version":{"number":"7.10.2","distribution":"default"}}`, ""},
+		{"opensearch", `{"version":{"number":"2.11.0","distribution":"opensearch"}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bulkBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/" {
+					_, _ = w.Write([]byte(tt.info))
+					return
+				}
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				bulkBody = body
+				_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`))
+			}))
+			defer srv.Close()
+			client, err := helpers.NewElasticSearchClient(srv.URL, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queue := NewDataQueue[*Hit]()
+			queue.Push(awkwardHits[:2]...)
+			queue.Stop()
+			opt := NewLoadDataOption()
+			opt.Action = ActionIndex
+			opt.Index = "target"
+			err = LoadData(client, queue, opt)
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			lines := parseBulk(t, bulkBody, opt.Action)
+			if len(lines) != 2 {
+				t.Fatalf("got %d actions, want 2", len(lines))
+			}
+			for _, line := range lines {
+				if line.meta.Type != tt.wantType {
+					t.Errorf("got _type: %q, want %q", line.meta.Type, tt.wantType)
+				}
+			}
+		})
+	}
+}
+
+// TestMaxRetriesCountsBulkRequests a bulk rejected with 503 is sent MaxRetries+1 times, the transport
+// of a load client does not retry on its own
+func TestMaxRetriesCountsBulkRequests(t *testing.T) {
+	var bulks atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		bulks.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"unavailable","status":503}`))
+	}))
+	defer srv.Close()
+	client, err := helpers.NewElasticSearchClient(srv.URL, false, helpers.WithoutTransportRetry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := NewLoadDataOption()
+	opt.Action = ActionIndex
+	opt.Index = "target"
+	opt.Version = 8
+	opt.MaxRetries = 2
+	opt.RetryBackoffMs = 1
+	_, _, err = bulkHits(context.Background(), client, opt, awkwardHits)
+	if err == nil {
+		t.Fatal("bulk rejected with 503 succeeded")
+	}
+	if got := bulks.Load(); got != int32(opt.MaxRetries+1) {
+		t.Errorf("got %d bulk requests, want %d", got, opt.MaxRetries+1)
+	}
+}
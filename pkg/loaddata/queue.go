@@ -12,7 +12,8 @@ import (
 
 const minQueueCapacity = 16
 
-// DataQueue a buffered FIFO data queue backed by a circular ring buffer
+// DataQueue a buffered FIFO data queue backed by a circular ring buffer,
+// optionally bounded by item count and byte budget
 type DataQueue[T any] struct {
 	mu      sync.Mutex
 	cond    *sync.Cond
@@ -20,16 +21,42 @@ type DataQueue[T any] struct {
 	head    int
 	count   int
 	stopped bool
+
+	maxItems int
+	maxBytes int64
+	bytes    int64
+	sizeOf   func(T) int
 }
 
 func NewDataQueue[T any]() *DataQueue[T] {
+	return NewBoundedDataQueue[T](0, 0, nil)
+}
+
+// NewBoundedDataQueue creates a queue whose Push blocks while it holds maxItems items
+// or maxBytes bytes measured by sizeOf, a limit <= 0 is unbounded
+func NewBoundedDataQueue[T any](maxItems int, maxBytes int64, sizeOf func(T) int) *DataQueue[T] {
+	if sizeOf == nil {
+		maxBytes = 0
+	}
 	q := &DataQueue[T]{
-		buf: make([]T, minQueueCapacity),
+		buf:      make([]T, minQueueCapacity),
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		sizeOf:   sizeOf,
 	}
 	q.cond = sync.NewCond(&q.mu)
 	return q
 }
 
+// full reports whether a push must wait, an empty queue always accepts one item
+// so that an item bigger than maxBytes can not block forever; must be called with lock held.
+func (q *DataQueue[T]) full() bool {
+	if q.maxItems > 0 && q.count >= q.maxItems {
+		return true
+	}
+	return q.maxBytes > 0 && q.count > 0 && q.bytes >= q.maxBytes
+}
+
 // grow doubles the buffer; must be called with lock held and count == len(buf).
 func (q *DataQueue[T]) grow() {
 	newBuf := make([]T, len(q.buf)*2)
@@ -43,26 +70,44 @@ func (q *DataQueue[T]) grow() {
 	q.head = 0
 }
 
+// Push appends data to the queue, blocking while a bounded queue is full.
+// It returns false if the queue was stopped before all data was pushed.
 func (q *DataQueue[T]) Push(data ...T) bool {
 	if len(data) == 0 {
 		return true
 	}
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if q.stopped {
-		return false
-	}
 	for _, item := range data {
+		for !q.stopped && q.full() {
+			// wake consumers before waiting for them to drain
+			q.cond.Broadcast()
+			q.cond.Wait()
+		}
+		if q.stopped {
+			return false
+		}
 		if q.count == len(q.buf) {
 			q.grow()
 		}
 		q.buf[(q.head+q.count)%len(q.buf)] = item
 		q.count++
+		if q.maxBytes > 0 {
+			q.bytes += int64(q.sizeOf(item))
+		}
 	}
 	q.cond.Broadcast()
 	return true
 }
 
+// Len returns the number of queued items
+func (q *DataQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Stop marks the end of data, blocked producers are released and consumers drain what is left
 func (q *DataQueue[T]) Stop() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -70,7 +115,15 @@ func (q *DataQueue[T]) Stop() {
 	q.cond.Broadcast()
 }
 
+// Pop removes up to limit items, a limit <= 0 takes everything queued. It blocks while the queue is empty
+// and returns nil once it is stopped and drained.
 func (q *DataQueue[T]) Pop(limit int) []T {
+	return q.PopBytes(limit, 0, nil)
+}
+
+// PopBytes is Pop also stopping before the items measured by sizeOf exceed maxBytes,
+// the first item is always taken even when it alone exceeds maxBytes
+func (q *DataQueue[T]) PopBytes(limit int, maxBytes int64, sizeOf func(T) int) []T {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	for {
@@ -79,6 +132,16 @@ func (q *DataQueue[T]) Pop(limit int) []T {
 			if limit > 0 && n > limit {
 				n = limit
 			}
+			if maxBytes > 0 && sizeOf != nil {
+				size := int64(0)
+				for i := 0; i < n; i++ {
+					size += int64(sizeOf(q.buf[(q.head+i)%len(q.buf)]))
+					if i > 0 && size > maxBytes {
+						n = i
+						break
+					}
+				}
+			}
 			ret := make([]T, n)
 			if q.head+n <= len(q.buf) {
 				copy(ret, q.buf[q.head:q.head+n])
@@ -92,8 +155,15 @@ func (q *DataQueue[T]) Pop(limit int) []T {
 			for i := 0; i < n; i++ {
 				q.buf[(q.head+i)%len(q.buf)] = zero
 			}
+			if q.maxBytes > 0 {
+				for _, item := range ret {
+					q.bytes -= int64(q.sizeOf(item))
+				}
+			}
 			q.head = (q.head + n) % len(q.buf)
 			q.count -= n
+			// wake producers waiting for room
+			q.cond.Broadcast()
 			return ret
 		}
 		if q.stopped {
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPopBytes(t *testing.T) {
+	queue := NewDataQueue[int]()
+	queue.Push(3, 3, 3, 10, 1, 1)
+	queue.Stop()
+	size := func(n int) int { return n }
+	var got [][]int
+	for {
+		items := queue.PopBytes(4, 6, size)
+		if items == nil {
+			break
+		}
+		got = append(got, items)
+	}
+	// the oversized item is popped alone, the count limit applies together with the byte limit
+	want := [][]int{{3, 3}, {3}, {10}, {1, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// pushAsync pushes data in the background, the result is sent once Push returns
+func pushAsync[T any](queue *DataQueue[T], data ...T) <-chan bool {
+	done := make(chan bool, 1)
+	go func() {
+		done <- queue.Push(data...)
+	}()
+	return done
+}
+
+func assertBlocked(t *testing.T, done <-chan bool) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("push returned, want it blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertReturned(t *testing.T, done <-chan bool, want bool) {
+	t.Helper()
+	select {
+	case got := <-done:
+		if got != want {
+			t.Fatalf("push returned %v, want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked")
+	}
+}
+
+func TestPushBlocksAtByteLimit(t *testing.T) {
+	size := func(n int) int { return n }
+	queue := NewBoundedDataQueue(0, 10, size)
+	// the queue takes items until their bytes reach the limit
+	assertReturned(t, pushAsync(queue, 6, 6), true)
+	done := pushAsync(queue, 1)
+	assertBlocked(t, done)
+	if got := queue.Len(); got != 2 {
+		t.Fatalf("got %d queued, want 2", got)
+	}
+	if got := queue.Pop(1); !reflect.DeepEqual(got, []int{6}) {
+		t.Fatalf("got %v, want [6]", got)
+	}
+	assertReturned(t, done, true)
+	// an item larger than the limit still goes into an empty queue
+	queue.Pop(0)
+	assertReturned(t, pushAsync(queue, 100), true)
+}
+
+func TestPushBlocksAtItemLimit(t *testing.T) {
+	queue := NewBoundedDataQueue[int](2, 0, nil)
+	done := pushAsync(queue, 1, 2, 3)
+	assertBlocked(t, done)
+	if got := queue.Pop(0); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("got %v, want [1 2]", got)
+	}
+	assertReturned(t, done, true)
+	if got := queue.Pop(0); !reflect.DeepEqual(got, []int{3}) {
+		t.Fatalf("got %v, want [3]", got)
+	}
+}
+
+func TestStopReleasesBlockedPush(t *testing.T) {
+	size := func(n int) int { return n }
+	queue := NewBoundedDataQueue(0, 10, size)
+	queue.Push(10)
+	done := pushAsync(queue, 1)
+	assertBlocked(t, done)
+	queue.Stop()
+	assertReturned(t, done, false)
+	// the consumer still drains what was queued before the stop
+	if got := queue.Pop(0); !reflect.DeepEqual(got, []int{10}) {
+		t.Fatalf("got %v, want [10]", got)
+	}
+	if got := queue.Pop(0); got != nil {
+		t.Fatalf("got %v after drain, want nil", got)
+	}
+}
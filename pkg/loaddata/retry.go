@@ -0,0 +1,73 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package loaddata
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bulkStatusError a bulk request answered with an error status
+type bulkStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *bulkStatusError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// retryableStatus reports whether a request or item rejected with status is worth retrying:
+// the cluster is overloaded (429) or temporarily unavailable (5xx)
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// isTimeout reports whether a request failed because the cluster did not answer in time,
+// a sign of overload like 429 that is worth retrying
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBackoff returns the wait before the retry following attempt (0 based),
+// doubling from initial up to limit with jitter in [d/2, d]
+func retryBackoff(attempt int, initial, limit time.Duration) time.Duration {
+	d := initial
+	for i := 0; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// sleepContext waits for d unless ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
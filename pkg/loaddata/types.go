@@ -11,22 +11,75 @@ import (
 )
 
 type Hit struct {
-	ID      string          `json:"_id"`
+	// Index the index the hit was dumped from
+	Index string `json:"_index,omitempty"`
+	ID    string `json:"_id"`
+	// Type mapping type of hits dumped from 5.x/6.x, empty for typeless indexes
+	Type    string          `json:"_type,omitempty"`
 	Routing string          `json:"_routing"`
 	Source  json.RawMessage `json:"_source"`
+	// Version, SeqNo and PrimaryTerm are only dumped by dump data --with-version
+	Version     *int64 `json:"_version,omitempty"`
+	SeqNo       *int64 `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64 `json:"_primary_term,omitempty"`
+	// Line line number of the hit in the input file, set by LoadHits
+	Line int64 `json:"-"`
+	// seq position of the hit among the pushed hits, counted from 1, set by LoadHits
+	seq int64
 }
 
+// HitSize approximates the memory held by a hit, used as the byte budget of a bounded queue
+func HitSize(h *Hit) int {
+	return len(h.Index) + len(h.ID) + len(h.Type) + len(h.Routing) + len(h.Source)
+}
+
+// bulkMeta the metadata of a bulk action line, empty fields are omitted
+type bulkMeta struct {
+	Index       string `json:"_index"`
+	Type        string `json:"_type,omitempty"`
+	ID          string `json:"_id,omitempty"`
+	Routing     string `json:"routing,omitempty"`
+	Version     *int64 `json:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty"`
+}
+
+// bulkSize approximates the bytes a hit adds to a bulk request, the source plus its action line
+func bulkSize(h *Hit) int {
+	return HitSize(h) + bulkMetaOverhead
+}
+
+// bulkMetaOverhead the json keys and punctuation of an action line
+const bulkMetaOverhead = 64
+
 type BulkResponse struct {
-	Took   int  `json:"took"`
-	Errors bool `json:"errors"`
-	Items  []struct {
-		Create struct {
-			Index  string          `json:"_index"`
-			Type   string          `json:"_type"`
-			ID     string          `json:"_id"`
-			Status int             `json:"status"`
-			Result string          `json:"result"`
-			Error  json.RawMessage `json:"error"`
-		} `json:"create"`
-	} `json:"items"`
+	Took   int                `json:"took"`
+	Errors bool               `json:"errors"`
+	Items  []BulkResponseItem `json:"items"`
+}
+
+// BulkResponseItem the result of one bulk action, keyed by the action name
+type BulkResponseItem struct {
+	Create *BulkItemResult `json:"create"`
+	Index  *BulkItemResult `json:"index"`
+	Update *BulkItemResult `json:"update"`
+	Delete *BulkItemResult `json:"delete"`
+}
+
+// Result returns the result of whichever action was sent, nil if none
+func (r *BulkResponseItem) Result() *BulkItemResult {
+	for _, res := range []*BulkItemResult{r.Create, r.Index, r.Update, r.Delete} {
+		if res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+type BulkItemResult struct {
+	Index  string          `json:"_index"`
+	Type   string          `json:"_type"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Result string          `json:"result"`
+	Error  json.RawMessage `json:"error"`
 }
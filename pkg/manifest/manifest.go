@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/pkg/errors"
+)
+
+// FileName name of the manifest inside a dump directory
+const FileName = "manifest.json"
+
+// Manifest lists what a dump directory or backup archive holds, file names are relative to it
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Indices   []*Index  `json:"indices"`
+	// Objects cluster level objects such as templates and pipelines, in the order to restore them
+	Objects []*Object `json:"objects,omitempty"`
+}
+
+// Object a cluster level object, Kind is a cluster.Kind
+type Object struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+type Index struct {
+	Name    string `json:"name"`
+	Mapping string `json:"mapping"`
+	Data    string `json:"data"`
+	Count   int    `json:"count"`
+}
+
+// Load reads the manifest of dir
+func Load(dir string) (*Manifest, error) {
+	file := filepath.Join(dir, FileName)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read manifest: %s failed", file)
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse manifest: %s failed", file)
+	}
+	return m, nil
+}
+
+// Marshal encodes the manifest as written by Save
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// Save writes the manifest into dir
+func (m *Manifest) Save(dir string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(filepath.Join(dir, FileName), data)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package mapping
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AliasRecreate adds the aliases to the loaded index as they were
+	AliasRecreate = "recreate"
+	// AliasRemap moves the aliases from whatever index holds them onto the loaded index in one atomic request
+	AliasRemap = "remap"
+	// AliasSkip does not touch aliases
+	AliasSkip = "skip"
+)
+
+// ValidateAliasMode checks mode is one of AliasRecreate, AliasRemap or AliasSkip
+func ValidateAliasMode(mode string) error {
+	switch mode {
+	case AliasRecreate, AliasRemap, AliasSkip:
+		return nil
+	default:
+		return errors.Errorf("unknown alias mode: %s, should be one of: recreate, remap, skip", mode)
+	}
+}
+
+// ParseAliases returns alias name to its options (filter, routing, is_write_index...) from the output
+// of the get index or get alias api for a single index
+func ParseAliases(data []byte) (map[string]json.RawMessage, error) {
+	var rootMap map[string]struct {
+		Aliases map[string]json.RawMessage `json:"aliases"`
+	}
+	err := json.Unmarshal(data, &rootMap)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(rootMap) > 1 {
+		return nil, errors.Errorf("multiple indexes: %v", len(rootMap))
+	}
+	aliases := map[string]json.RawMessage{}
+	for _, v := range rootMap {
+		for name, opt := range v.Aliases {
+			aliases[name] = opt
+		}
+	}
+	return aliases, nil
+}
+
+// AliasActions builds the body of the update aliases api which points aliases at index according to mode,
+// nil is returned when there is nothing to do
+func AliasActions(index string, aliases map[string]json.RawMessage, mode string) ([]byte, error) {
+	err := ValidateAliasMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if mode == AliasSkip || len(aliases) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	actions := []map[string]interface{}{}
+	for _, name := range names {
+		if mode == AliasRemap {
+			actions = append(actions, map[string]interface{}{
+				"remove": map[string]interface{}{"index": "*", "alias": name, "must_exist": false},
+			})
+		}
+		// raw values keep large numbers in filters intact
+		add := map[string]json.RawMessage{}
+		if len(aliases[name]) > 0 {
+			err = json.Unmarshal(aliases[name], &add)
+			if err != nil {
+				return nil, errors.Wrapf(err, "alias: %s", name)
+			}
+		}
+		add["index"], _ = json.Marshal(index)
+		add["alias"], _ = json.Marshal(name)
+		actions = append(actions, map[string]interface{}{"add": add})
+	}
+	data, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
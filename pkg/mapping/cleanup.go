@@ -32,6 +32,9 @@ func CleanUpMapping(data string) (string, error) {
 		return "", errors.WithStack(err)
 	}
 	
+	// aliases are put after the index is created, see AliasActions
+	delete(dataMap, "aliases")
+
 	//Handle possible lack of settings object in the mapping.
 	if settingsData, ok := dataMap["settings"]; ok  {
 		var settingsMap map[string]json.RawMessage
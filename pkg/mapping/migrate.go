@@ -0,0 +1,331 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LatestVersion the major version a mapping is migrated to when the target is unknown
+const LatestVersion = 9
+
+// rootParams are the keys allowed at the root of a typeless mapping, any other key is a mapping type
+var rootParams = map[string]bool{
+	"properties":             true,
+	"dynamic":                true,
+	"dynamic_templates":      true,
+	"date_detection":         true,
+	"dynamic_date_formats":   true,
+	"numeric_detection":      true,
+	"enabled":                true,
+	"runtime":                true,
+	"subobjects":             true,
+	"_source":                true,
+	"_routing":               true,
+	"_meta":                  true,
+	"_all":                   true,
+	"_field_names":           true,
+	"_size":                  true,
+	"_parent":                true,
+	"_timestamp":             true,
+	"_ttl":                   true,
+	"_data_stream_timestamp": true,
+}
+
+// SourceVersion returns the major version of elasticsearch the index of a dumped mapping was created by, 0 if unknown
+func SourceVersion(data string) int {
+	var rootMap map[string]struct {
+		Settings struct {
+			Index struct {
+				Version struct {
+					Created       string `json:"created"`
+					CreatedString string `json:"created_string"`
+				} `json:"version"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	err := json.Unmarshal([]byte(data), &rootMap)
+	if err != nil || len(rootMap) != 1 {
+		return 0
+	}
+	for _, v := range rootMap {
+		version := v.Settings.Index.Version
+		if version.CreatedString != "" {
+			major, err := strconv.Atoi(strings.SplitN(version.CreatedString, ".", 2)[0])
+			if err == nil {
+				return major
+			}
+		}
+		// the id is major*1000000 + minor*10000 + revision*100 + build, index versions of 8.11+ keep the major prefix
+		created, err := strconv.Atoi(version.Created)
+		if err == nil {
+			return created / 1000000
+		}
+	}
+	return 0
+}
+
+// MigrateMapping rewrites a create index body, as returned by CleanUpMapping, for elasticsearch of major version target:
+// mapping types are removed, string fields become text or keyword and dropped parameters are deleted.
+// It returns the new body and a description of every change, a body valid for target is returned unchanged.
+func MigrateMapping(body string, target int) (string, []string, error) {
+	if target <= 0 {
+		target = LatestVersion
+	}
+	root, err := decodeObject([]byte(body))
+	if err != nil {
+		return "", nil, err
+	}
+	m := &migrator{target: target}
+	if mappings, ok := root["mappings"].(map[string]interface{}); ok {
+		root["mappings"], err = m.migrateMappings(mappings)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if settings, ok := root["settings"].(map[string]interface{}); ok {
+		m.migrateSettings(settings)
+	}
+	if len(m.changes) == 0 {
+		return body, nil, nil
+	}
+	newData, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+	return string(newData), m.changes, nil
+}
+
+// Normalize formats a json document with sorted keys and indent, so two documents can be compared line by line
+func Normalize(body string) (string, error) {
+	obj, err := decodeObject([]byte(body))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(data), nil
+}
+
+// decodeObject keeps numbers as json.Number, so large longs survive a round trip
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var obj map[string]interface{}
+	err := decoder.Decode(&obj)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return obj, nil
+}
+
+type migrator struct {
+	target  int
+	changes []string
+}
+
+func (m *migrator) notef(format string, args ...interface{}) {
+	m.changes = append(m.changes, fmt.Sprintf(format, args...))
+}
+
+// migrateMappings handles the typed mappings of 6.x and earlier, {"<type>": {"properties": ...}}
+func (m *migrator) migrateMappings(mappings map[string]interface{}) (map[string]interface{}, error) {
+	types := []string{}
+	for key := range mappings {
+		if !rootParams[key] {
+			types = append(types, key)
+		}
+	}
+	sort.Strings(types)
+	if len(types) == 0 || len(types) != len(mappings) {
+		m.migrateType("mappings", mappings)
+		return mappings, nil
+	}
+	if m.target < 7 {
+		for _, name := range types {
+			if tm, ok := mappings[name].(map[string]interface{}); ok {
+				m.migrateType("mappings."+name, tm)
+			}
+		}
+		return mappings, nil
+	}
+	if _, ok := mappings["_default_"]; ok {
+		delete(mappings, "_default_")
+		m.notef("mappings._default_: removed, default mappings are not supported since 7.0")
+		types = types[:0]
+		for key := range mappings {
+			types = append(types, key)
+		}
+		sort.Strings(types)
+	}
+	if len(types) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	if len(types) > 1 {
+		return nil, errors.Errorf("multiple mapping types: %s, an index has at most one type since 6.0, load them into separate indexes", strings.Join(types, ", "))
+	}
+	tm, ok := mappings[types[0]].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("mapping type: %s is not an object", types[0])
+	}
+	m.notef("mappings.%s: removed mapping type, fields moved to mappings", types[0])
+	m.migrateType("mappings", tm)
+	return tm, nil
+}
+
+// migrateType migrates the root of one mapping, with its metadata fields, properties and dynamic templates
+func (m *migrator) migrateType(path string, tm map[string]interface{}) {
+	if m.target >= 6 {
+		m.drop(path, tm, "_all", "the _all field was removed in 6.0, use copy_to")
+		m.drop(path, tm, "_parent", "parent/child needs a join field since 6.0")
+	}
+	if m.target >= 5 {
+		m.drop(path, tm, "_timestamp", "removed in 5.0, use a date field")
+		m.drop(path, tm, "_ttl", "removed in 5.0")
+	}
+	if m.target >= 8 {
+		m.drop(path, tm, "_field_names", "its settings were removed in 8.0")
+	}
+	if properties, ok := tm["properties"].(map[string]interface{}); ok {
+		m.migrateProperties(path+".properties", properties)
+	}
+	if templates, ok := tm["dynamic_templates"].([]interface{}); ok {
+		for i, t := range templates {
+			named, ok := t.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for name, v := range named {
+				template, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if field, ok := template["mapping"].(map[string]interface{}); ok {
+					m.migrateField(fmt.Sprintf("%s.dynamic_templates[%d].%s.mapping", path, i, name), field)
+				}
+			}
+		}
+	}
+}
+
+func (m *migrator) migrateProperties(path string, properties map[string]interface{}) {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if field, ok := properties[name].(map[string]interface{}); ok {
+			m.migrateField(path+"."+name, field)
+		}
+	}
+}
+
+func (m *migrator) migrateField(path string, field map[string]interface{}) {
+	if m.target >= 5 {
+		if field["type"] == "string" {
+			m.migrateString(path, field)
+		}
+		switch field["index"] {
+		case "no":
+			field["index"] = false
+			m.notef("%s.index: no -> false", path)
+		case "analyzed", "not_analyzed":
+			m.notef("%s.index: %s -> true", path, field["index"])
+			field["index"] = true
+		}
+		if norms, ok := field["norms"].(map[string]interface{}); ok {
+			enabled := norms["enabled"] != false && norms["enabled"] != "false"
+			field["norms"] = enabled
+			m.notef("%s.norms: object -> %v", path, enabled)
+		}
+	}
+	if m.target >= 6 {
+		m.drop(path, field, "include_in_all", "the _all field was removed in 6.0")
+		if field["type"] == "geo_point" {
+			for _, param := range []string{"lat_lon", "geohash", "geohash_precision", "geohash_prefix"} {
+				m.drop(path, field, param, "removed from geo_point")
+			}
+		}
+	}
+	if properties, ok := field["properties"].(map[string]interface{}); ok {
+		m.migrateProperties(path+".properties", properties)
+	}
+	if fields, ok := field["fields"].(map[string]interface{}); ok {
+		m.migrateProperties(path+".fields", fields)
+	}
+}
+
+// migrateString converts the string type of 2.x, a not analyzed string becomes keyword and others become text
+func (m *migrator) migrateString(path string, field map[string]interface{}) {
+	switch field["index"] {
+	case "not_analyzed":
+		field["type"] = "keyword"
+		delete(field, "index")
+	case "no":
+		field["type"] = "keyword"
+		field["index"] = false
+	default:
+		field["type"] = "text"
+		if field["index"] == "analyzed" {
+			delete(field, "index")
+		}
+	}
+	m.notef("%s.type: string -> %s", path, field["type"])
+	if field["type"] == "keyword" {
+		for _, param := range []string{"analyzer", "search_analyzer", "search_quote_analyzer", "position_increment_gap", "term_vector", "fielddata"} {
+			m.drop(path, field, param, "not supported by keyword")
+		}
+		return
+	}
+	m.drop(path, field, "ignore_above", "not supported by text")
+	m.drop(path, field, "doc_values", "not supported by text")
+	if _, ok := field["fielddata"].(map[string]interface{}); ok {
+		m.drop(path, field, "fielddata", "text fielddata is a boolean")
+	}
+}
+
+// migrateSettings drops the index settings removed in later versions, both flat and nested keys are handled
+func (m *migrator) migrateSettings(settings map[string]interface{}) {
+	if m.target < 7 {
+		return
+	}
+	index, ok := settings["index"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	m.drop("settings.index", index, "mapping.single_type", "removed in 7.0")
+	m.drop("settings.index", index, "mapper.dynamic", "removed in 7.0")
+	if mapping, ok := index["mapping"].(map[string]interface{}); ok {
+		m.drop("settings.index.mapping", mapping, "single_type", "removed in 7.0")
+		if len(mapping) == 0 {
+			delete(index, "mapping")
+		}
+	}
+	if mapper, ok := index["mapper"].(map[string]interface{}); ok {
+		m.drop("settings.index.mapper", mapper, "dynamic", "removed in 7.0")
+		if len(mapper) == 0 {
+			delete(index, "mapper")
+		}
+	}
+}
+
+func (m *migrator) drop(path string, obj map[string]interface{}, key, reason string) {
+	if _, ok := obj[key]; ok {
+		delete(obj, key)
+		m.notef("%s.%s: removed, %s", path, key, reason)
+	}
+}
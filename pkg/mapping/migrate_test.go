@@ -0,0 +1,325 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package mapping
+
+import (
+	"strings"
+	"testing"
+)
+
+// mapping2x as dumped from elasticsearch 2.4: one type, string fields, _all, _timestamp and include_in_all
+const mapping2x = `{
+  "logs-2016": {
+    "aliases": {"logs": {}},
+    "mappings": {
+      "event": {
+        "_all": {"enabled": false},
+        "_timestamp": {"enabled": true},
+        "dynamic_templates": [
+          {"strings": {"match_mapping_type": "string", "mapping": {"type": "string", "index": "not_analyzed", "doc_values": true}}}
+        ],
+        "properties": {
+          "message": {"type": "string", "analyzer": "standard", "include_in_all": true, "norms": {"enabled": false}},
+          "host": {"type": "string", "index": "not_analyzed", "ignore_above": 256, "analyzer": "keyword"},
+          "raw": {"type": "string", "index": "no"},
+          "status": {"type": "integer", "index": "not_analyzed"},
+          "title": {
+            "type": "string",
+            "index": "analyzed",
+            "fields": {"raw": {"type": "string", "index": "not_analyzed"}}
+          },
+          "user": {"properties": {"name": {"type": "string", "include_in_all": false}}},
+          "location": {"type": "geo_point", "lat_lon": true, "geohash": true}
+        }
+      }
+    },
+    "settings": {
+      "index": {
+        "creation_date": "1466000000000",
+        "number_of_shards": "5",
+        "number_of_replicas": "1",
+        "uuid": "kTSP8wd2R8qm0MVkIxk0dA",
+        "version": {"created": "2040299"}
+      }
+    }
+  }
+}`
+
+// mapping5x as dumped from elasticsearch 5.6: a _default_ mapping besides the type
+const mapping5x = `{
+  "orders": {
+    "aliases": {},
+    "mappings": {
+      "_default_": {"_all": {"enabled": false}},
+      "order": {
+        "_all": {"enabled": false},
+        "_parent": {"type": "customer"},
+        "properties": {
+          "id": {"type": "keyword"},
+          "note": {"type": "text", "include_in_all": false},
+          "placed_at": {"type": "date", "format": "strict_date_optional_time||epoch_millis"},
+          "amount": {"type": "scaled_float", "scaling_factor": 100}
+        }
+      }
+    },
+    "settings": {
+      "index": {
+        "number_of_shards": "3",
+        "number_of_replicas": "1",
+        "mapper": {"dynamic": "false"},
+        "version": {"created": "5061699"}
+      }
+    }
+  }
+}`
+
+// mapping5xMultiType as dumped from elasticsearch 5.6, an index with two types
+const mapping5xMultiType = `{
+  "blog": {
+    "mappings": {
+      "post": {"properties": {"title": {"type": "text"}}},
+      "comment": {"properties": {"body": {"type": "text"}}}
+    },
+    "settings": {"index": {"number_of_shards": "1", "version": {"created": "5061699"}}}
+  }
+}`
+
+// mapping6x as dumped from elasticsearch 6.8: the single _doc type
+const mapping6x = `{
+  "products": {
+    "aliases": {},
+    "mappings": {
+      "_doc": {
+        "_field_names": {"enabled": false},
+        "dynamic": "strict",
+        "properties": {
+          "name": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+          "price": {"type": "double"},
+          "tags": {"type": "keyword"}
+        }
+      }
+    },
+    "settings": {
+      "index": {
+        "number_of_shards": "1",
+        "mapping": {"single_type": "true"},
+        "version": {"created": "6080099"}
+      }
+    }
+  }
+}`
+
+// mapping7x as dumped from elasticsearch 7.17, already typeless
+const mapping7x = `{
+  "metrics": {
+    "aliases": {},
+    "mappings": {"properties": {"value": {"type": "long"}, "at": {"type": "date"}}},
+    "settings": {"index": {"number_of_shards": "1", "version": {"created": "7170099"}}}
+  }
+}`
+
+func TestSourceVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"2.x", mapping2x, 2},
+		{"5.x", mapping5x, 5},
+		{"6.x", mapping6x, 6},
+		{"7.x", mapping7x, 7},
+		{"created string", `{"i": {"settings": {"index": {"version": {"created": "6080099", "created_string": "6.8.0"}}}}}`, 6},
+		{"8.11+ index version", `{"i": {"settings": {"index": {"version": {"created": "8500003"}}}}}`, 8},
+		{"no version", `{"i": {"mappings": {}}}`, 0},
+		{"multiple indexes", `{"a": {}, "b": {}}`, 0},
+		{"invalid json", `{`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SourceVersion(tt.data); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		target int
+		// want the migrated create index body, empty when the body is returned unchanged
+		want    string
+		changes []string
+	}{
+		{
+			name:   "2.x to 8",
+			data:   mapping2x,
+			target: 8,
+			want:   `{"mappings":{"dynamic_templates":[{"strings":{"mapping":{"doc_values":true,"type":"keyword"},"match_mapping_type":"string"}}],"properties":{"host":{"ignore_above":256,"type":"keyword"},"location":{"type":"geo_point"},"message":{"analyzer":"standard","norms":false,"type":"text"},"raw":{"index":false,"type":"keyword"},"status":{"index":true,"type":"integer"},"title":{"fields":{"raw":{"type":"keyword"}},"type":"text"},"user":{"properties":{"name":{"type":"text"}}}}},"settings":{"index":{"number_of_replicas":"1","number_of_shards":"5"}}}`,
+			changes: []string{
+				"mappings.event: removed mapping type, fields moved to mappings",
+				"mappings._all: removed, the _all field was removed in 6.0, use copy_to",
+				"mappings._timestamp: removed, removed in 5.0, use a date field",
+				"mappings.properties.host.type: string -> keyword",
+				"mappings.properties.host.analyzer: removed, not supported by keyword",
+				"mappings.properties.location.lat_lon: removed, removed from geo_point",
+				"mappings.properties.location.geohash: removed, removed from geo_point",
+				"mappings.properties.message.type: string -> text",
+				"mappings.properties.message.norms: object -> false",
+				"mappings.properties.message.include_in_all: removed, the _all field was removed in 6.0",
+				"mappings.properties.raw.type: string -> keyword",
+				"mappings.properties.status.index: not_analyzed -> true",
+				"mappings.properties.title.type: string -> text",
+				"mappings.properties.title.fields.raw.type: string -> keyword",
+				"mappings.properties.user.properties.name.type: string -> text",
+				"mappings.properties.user.properties.name.include_in_all: removed, the _all field was removed in 6.0",
+				"mappings.dynamic_templates[0].strings.mapping.type: string -> keyword",
+			},
+		},
+		{
+			name:   "2.x to 5 keeps the type",
+			data:   mapping2x,
+			target: 5,
+			want:   `{"mappings":{"event":{"_all":{"enabled":false},"dynamic_templates":[{"strings":{"mapping":{"doc_values":true,"type":"keyword"},"match_mapping_type":"string"}}],"properties":{"host":{"ignore_above":256,"type":"keyword"},"location":{"geohash":true,"lat_lon":true,"type":"geo_point"},"message":{"analyzer":"standard","include_in_all":true,"norms":false,"type":"text"},"raw":{"index":false,"type":"keyword"},"status":{"index":true,"type":"integer"},"title":{"fields":{"raw":{"type":"keyword"}},"type":"text"},"user":{"properties":{"name":{"include_in_all":false,"type":"text"}}}}}},"settings":{"index":{"number_of_replicas":"1","number_of_shards":"5"}}}`,
+			changes: []string{
+				"mappings.event._timestamp: removed, removed in 5.0, use a date field",
+				"mappings.event.properties.host.type: string -> keyword",
+				"mappings.event.properties.host.analyzer: removed, not supported by keyword",
+				"mappings.event.properties.message.type: string -> text",
+				"mappings.event.properties.message.norms: object -> false",
+				"mappings.event.properties.raw.type: string -> keyword",
+				"mappings.event.properties.status.index: not_analyzed -> true",
+				"mappings.event.properties.title.type: string -> text",
+				"mappings.event.properties.title.fields.raw.type: string -> keyword",
+				"mappings.event.properties.user.properties.name.type: string -> text",
+				"mappings.event.dynamic_templates[0].strings.mapping.type: string -> keyword",
+			},
+		},
+		{
+			name:   "5.x to 7",
+			data:   mapping5x,
+			target: 7,
+			want:   `{"mappings":{"properties":{"amount":{"scaling_factor":100,"type":"scaled_float"},"id":{"type":"keyword"},"note":{"type":"text"},"placed_at":{"format":"strict_date_optional_time||epoch_millis","type":"date"}}},"settings":{"index":{"number_of_replicas":"1","number_of_shards":"3"}}}`,
+			changes: []string{
+				"mappings._default_: removed, default mappings are not supported since 7.0",
+				"mappings.order: removed mapping type, fields moved to mappings",
+				"mappings._all: removed, the _all field was removed in 6.0, use copy_to",
+				"mappings._parent: removed, parent/child needs a join field since 6.0",
+				"mappings.properties.note.include_in_all: removed, the _all field was removed in 6.0",
+				"settings.index.mapper.dynamic: removed, removed in 7.0",
+			},
+		},
+		{
+			name:   "5.x to 6 keeps the types",
+			data:   mapping5x,
+			target: 6,
+			want:   `{"mappings":{"_default_":{},"order":{"properties":{"amount":{"scaling_factor":100,"type":"scaled_float"},"id":{"type":"keyword"},"note":{"type":"text"},"placed_at":{"format":"strict_date_optional_time||epoch_millis","type":"date"}}}},"settings":{"index":{"mapper":{"dynamic":"false"},"number_of_replicas":"1","number_of_shards":"3"}}}`,
+			changes: []string{
+				"mappings._default_._all: removed, the _all field was removed in 6.0, use copy_to",
+				"mappings.order._all: removed, the _all field was removed in 6.0, use copy_to",
+				"mappings.order._parent: removed, parent/child needs a join field since 6.0",
+				"mappings.order.properties.note.include_in_all: removed, the _all field was removed in 6.0",
+			},
+		},
+		{
+			name:   "6.x to 7",
+			data:   mapping6x,
+			target: 7,
+			want:   `{"mappings":{"_field_names":{"enabled":false},"dynamic":"strict","properties":{"name":{"fields":{"keyword":{"ignore_above":256,"type":"keyword"}},"type":"text"},"price":{"type":"double"},"tags":{"type":"keyword"}}},"settings":{"index":{"number_of_shards":"1"}}}`,
+			changes: []string{
+				"mappings._doc: removed mapping type, fields moved to mappings",
+				"settings.index.mapping.single_type: removed, removed in 7.0",
+			},
+		},
+		{
+			name:   "6.x to 8",
+			data:   mapping6x,
+			target: 8,
+			want:   `{"mappings":{"dynamic":"strict","properties":{"name":{"fields":{"keyword":{"ignore_above":256,"type":"keyword"}},"type":"text"},"price":{"type":"double"},"tags":{"type":"keyword"}}},"settings":{"index":{"number_of_shards":"1"}}}`,
+			changes: []string{
+				"mappings._doc: removed mapping type, fields moved to mappings",
+				"mappings._field_names: removed, its settings were removed in 8.0",
+				"settings.index.mapping.single_type: removed, removed in 7.0",
+			},
+		},
+		{
+			name:   "unknown target is the latest",
+			data:   mapping6x,
+			target: 0,
+			want:   `{"mappings":{"dynamic":"strict","properties":{"name":{"fields":{"keyword":{"ignore_above":256,"type":"keyword"}},"type":"text"},"price":{"type":"double"},"tags":{"type":"keyword"}}},"settings":{"index":{"number_of_shards":"1"}}}`,
+			changes: []string{
+				"mappings._doc: removed mapping type, fields moved to mappings",
+				"mappings._field_names: removed, its settings were removed in 8.0",
+				"settings.index.mapping.single_type: removed, removed in 7.0",
+			},
+		},
+		{name: "6.x to 6 is unchanged", data: mapping6x, target: 6},
+		{name: "7.x to 8 is unchanged", data: mapping7x, target: 8},
+		{name: "5.x types to 6 are unchanged", data: mapping5xMultiType, target: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := CleanUpMapping(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, changes, err := MigrateMapping(body, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if got != body || len(changes) != 0 {
+					t.Errorf("got changes: %v, want the body unchanged", changes)
+				}
+				return
+			}
+			if !jsonEqual(t, got, tt.want) {
+				t.Errorf("got body:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if strings.Join(changes, "\n") != strings.Join(tt.changes, "\n") {
+				t.Errorf("got changes:\n%s\nwant:\n%s", strings.Join(changes, "\n"), strings.Join(tt.changes, "\n"))
+			}
+		})
+	}
+}
+
+func TestMigrateMappingMultipleTypes(t *testing.T) {
+	body, err := CleanUpMapping(mapping5xMultiType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = MigrateMapping(body, 7)
+	if err == nil || !strings.Contains(err.Error(), "multiple mapping types: comment, post") {
+		t.Errorf("got error: %v, want multiple mapping types", err)
+	}
+}
+
+// TestMigrateMappingKeepsLongs checks numbers are not rounded through float64
+func TestMigrateMappingKeepsLongs(t *testing.T) {
+	body := `{"mappings": {"doc": {"_meta": {"max_id": 9007199254740993}, "properties": {"a": {"type": "string"}}}}}`
+	got, _, err := MigrateMapping(body, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "9007199254740993") {
+		t.Errorf("long rounded: %s", got)
+	}
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	na, err := Normalize(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb, err := Normalize(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return na == nb
+}
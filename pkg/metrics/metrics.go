@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets upper bounds (second) of the latency histograms
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counters of the stages of a job, written in the prometheus text format
+type Metrics struct {
+	mu         sync.Mutex
+	stages     []*Stage
+	queueDepth func() int
+}
+
+func New() *Metrics {
+	return &Metrics{}
+}
+
+// Stage returns the counters of the stage with name, such as dump or load, a nil Metrics returns a nil Stage
+func (m *Metrics) Stage(name string) *Stage {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.stages {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &Stage{
+		name:        name,
+		bulkLatency: newHistogram(latencyBuckets),
+		pageLatency: newHistogram(latencyBuckets),
+	}
+	m.stages = append(m.stages, s)
+	return s
+}
+
+// SetQueueDepth reports the documents buffered between the reader and the bulk workers with depth,
+// it replaces the queue set before
+func (m *Metrics) SetQueueDepth(depth func() int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queueDepth = depth
+}
+
+// Stage counters of one stage of a job, all methods are safe for concurrent use and a nil Stage does nothing
+type Stage struct {
+	name        string
+	read        atomic.Int64
+	written     atomic.Int64
+	failed      atomic.Int64
+	retries     atomic.Int64
+	bulkLatency *histogram
+	pageLatency *histogram
+}
+
+// AddRead counts documents read from the input of the stage
+func (s *Stage) AddRead(n int) {
+	if s != nil {
+		s.read.Add(int64(n))
+	}
+}
+
+// AddWritten counts documents written to the output of the stage
+func (s *Stage) AddWritten(n int) {
+	if s != nil {
+		s.written.Add(int64(n))
+	}
+}
+
+// AddFailed counts documents failed
+func (s *Stage) AddFailed(n int) {
+	if s != nil {
+		s.failed.Add(int64(n))
+	}
+}
+
+// AddRetries counts documents sent again after a rejection
+func (s *Stage) AddRetries(n int) {
+	if s != nil {
+		s.retries.Add(int64(n))
+	}
+}
+
+// ObserveBulk records the latency of a bulk request
+func (s *Stage) ObserveBulk(d time.Duration) {
+	if s != nil {
+		s.bulkLatency.observe(d.Seconds())
+	}
+}
+
+// ObservePage records the latency of a search or scroll page
+func (s *Stage) ObservePage(d time.Duration) {
+	if s != nil {
+		s.pageLatency.observe(d.Seconds())
+	}
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	stages := append([]*Stage(nil), m.stages...)
+	queueDepth := m.queueDepth
+	m.mu.Unlock()
+
+	ew := &errWriter{w: w}
+	counter := func(name, help string, value func(s *Stage) int64) {
+		ew.printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, s := range stages {
+			ew.printf("%s{stage=%q} %d\n", name, s.name, value(s))
+		}
+	}
+	counter("elasticdump_documents_read_total", "Documents read from the input of the stage.", func(s *Stage) int64 { return s.read.Load() })
+	counter("elasticdump_documents_written_total", "Documents written to the output of the stage.", func(s *Stage) int64 { return s.written.Load() })
+	counter("elasticdump_documents_failed_total", "Documents failed to index.", func(s *Stage) int64 { return s.failed.Load() })
+	counter("elasticdump_retries_total", "Documents sent again after a rejection with 429/5xx.", func(s *Stage) int64 { return s.retries.Load() })
+	hist := func(name, help string, value func(s *Stage) *histogram) {
+		ew.printf("# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, s := range stages {
+			h := value(s)
+			h.mu.Lock()
+			if h.count == 0 {
+				// a stage only sends bulks or only reads pages, skip the histogram it never uses
+				h.mu.Unlock()
+				continue
+			}
+			for i, le := range h.buckets {
+				ew.printf("%s_bucket{stage=%q,le=%q} %d\n", name, s.name, formatFloat(le), h.counts[i])
+			}
+			ew.printf("%s_bucket{stage=%q,le=\"+Inf\"} %d\n", name, s.name, h.count)
+			ew.printf("%s_sum{stage=%q} %s\n", name, s.name, formatFloat(h.sum))
+			ew.printf("%s_count{stage=%q} %d\n", name, s.name, h.count)
+			h.mu.Unlock()
+		}
+	}
+	hist("elasticdump_bulk_latency_seconds", "Latency of bulk requests.", func(s *Stage) *histogram { return s.bulkLatency })
+	hist("elasticdump_page_latency_seconds", "Latency of search and scroll pages.", func(s *Stage) *histogram { return s.pageLatency })
+	if queueDepth != nil {
+		name := "elasticdump_queue_depth"
+		ew.printf("# HELP %s Documents buffered between the reader and the bulk workers.\n# TYPE %s gauge\n", name, name)
+		ew.printf("%s %d\n", name, queueDepth())
+	}
+	return ew.n, ew.err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// errWriter keeps the first write error and skips the writes after it
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *errWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
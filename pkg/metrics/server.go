@@ -0,0 +1,48 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// Serve exposes m on http://addr/metrics until the returned stop is called
+func Serve(addr string, m *Metrics) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen metrics address: %s failed", addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		_, _ = m.WriteTo(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, err := w.Write(buf.Bytes())
+		if err != nil {
+			klog.V(4).Infof("write metrics failed: %v", err)
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			klog.Warningf("metrics server stopped: %v\n", err)
+		}
+	}()
+	klog.Infof("serve metrics on http://%s/metrics\n", ln.Addr())
+	return func() {
+		err := server.Close()
+		if err != nil {
+			klog.V(4).Infof("close metrics server failed: %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shinexia/elasticdump/pkg/helpers"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// Progress counts the documents and bytes of a dump, load or copy and logs the rate and eta periodically.
+// All methods are safe for concurrent use and a nil Progress does nothing.
+type Progress struct {
+	job    string
+	total  int64
+	start  time.Time
+	docs   atomic.Int64
+	bytes  atomic.Int64
+	failed atomic.Int64
+
+	mu sync.Mutex
+	// fraction reports the part of the job done, it replaces docs / total for an estimate such as the input consumed
+	fraction func() float64
+}
+
+// New starts the progress of job expecting total documents, 0 if unknown
+func New(job string, total int64) *Progress {
+	return &Progress{
+		job:   job,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// SetFraction estimates the done part of the job with fraction instead of the document count
+func (p *Progress) SetFraction(fraction func() float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fraction = fraction
+}
+
+// Add counts docs documents of bytes bytes done
+func (p *Progress) Add(docs, bytes int) {
+	if p == nil {
+		return
+	}
+	p.docs.Add(int64(docs))
+	p.bytes.Add(int64(bytes))
+}
+
+// Fail counts docs documents failed
+func (p *Progress) Fail(docs int) {
+	if p == nil {
+		return
+	}
+	p.failed.Add(int64(docs))
+}
+
+// done returns the part of the job done in [0, 1], or -1 if unknown
+func (p *Progress) done() float64 {
+	p.mu.Lock()
+	fraction := p.fraction
+	p.mu.Unlock()
+	if fraction != nil {
+		return min(fraction(), 1)
+	}
+	if p.total > 0 {
+		return min(float64(p.docs.Load()+p.failed.Load())/float64(p.total), 1)
+	}
+	return -1
+}
+
+// Log writes one progress line
+func (p *Progress) Log() {
+	if p == nil {
+		return
+	}
+	elapsed := time.Since(p.start)
+	docs, bytes, failed := p.docs.Load(), p.bytes.Load(), p.failed.Load()
+	seconds := max(elapsed.Seconds(), 0.001)
+	line := fmt.Sprintf("%s progress: docs: %d", p.job, docs)
+	if p.total > 0 {
+		line += fmt.Sprintf("/%d", p.total)
+	}
+	line += fmt.Sprintf(", failed: %d, bytes: %s, rate: %.0f docs/s, %s/s", failed, formatBytes(float64(bytes)), float64(docs)/seconds, formatBytes(float64(bytes)/seconds))
+	if done := p.done(); done > 0 {
+		eta := time.Duration(float64(elapsed) * (1 - done) / done)
+		line += fmt.Sprintf(", done: %.1f%%, eta: %v", done*100, eta.Round(time.Second))
+	}
+	klog.Info(line + "\n")
+}
+
+// Report logs the progress every interval until the returned stop is called
+func (p *Progress) Report(interval time.Duration) (stop func()) {
+	if p == nil || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.Log()
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Stats final counters of a job
+type Stats struct {
+	Job         string    `json:"job"`
+	Docs        int64     `json:"docs"`
+	Bytes       int64     `json:"bytes"`
+	Failed      int64     `json:"failed"`
+	Total       int64     `json:"total,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationSec float64   `json:"duration_sec"`
+	DocsPerSec  float64   `json:"docs_per_sec"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Stats returns the counters, err is the result of the job
+func (p *Progress) Stats(err error) *Stats {
+	now := time.Now()
+	stats := &Stats{
+		Job:         p.job,
+		Docs:        p.docs.Load(),
+		Bytes:       p.bytes.Load(),
+		Failed:      p.failed.Load(),
+		Total:       p.total,
+		StartedAt:   p.start,
+		FinishedAt:  now,
+		DurationSec: now.Sub(p.start).Seconds(),
+	}
+	if stats.DurationSec > 0 {
+		stats.DocsPerSec = float64(stats.Docs) / stats.DurationSec
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	return stats
+}
+
+// WriteStatsFile writes the stats of p as json to file
+func (p *Progress) WriteStatsFile(file string, err error) error {
+	data, merr := json.MarshalIndent(p.Stats(err), "", "  ")
+	if merr != nil {
+		return errors.WithStack(merr)
+	}
+	return helpers.WriteFileAtomic(file, append(data, '\n'))
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r     io.Reader
+	count atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count.Add(int64(n))
+	return n, err
+}
+
+// TrackReader estimates the done part of the job by the bytes read from r out of size,
+// for an input whose document count is unknown before it is read
+func (p *Progress) TrackReader(r io.Reader, size int64) io.Reader {
+	if p == nil || size <= 0 {
+		return r
+	}
+	cr := &countingReader{r: r}
+	p.SetFraction(func() float64 {
+		return float64(cr.count.Load()) / float64(size)
+	})
+	return cr
+}
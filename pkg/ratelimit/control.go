@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// LoadControlFile reads limits from a json file: {"max_docs_per_sec": 1000, "max_bytes_per_sec": 10485760}
+func LoadControlFile(file string) (Limits, error) {
+	limits := Limits{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return limits, errors.Wrapf(err, "read control file: %s failed", file)
+	}
+	err = json.Unmarshal(data, &limits)
+	if err != nil {
+		return limits, errors.Wrapf(err, "parse control file: %s failed", file)
+	}
+	return limits, nil
+}
+
+// Control adjusts the limits of l at runtime until ctx is done: the control file, if set, is applied whenever
+// it changes, SIGUSR1 halves and SIGUSR2 doubles the limits
+func Control(ctx context.Context, l *Limiter, controlFile string, interval time.Duration) {
+	signals, stop := notifySignals()
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var modTime time.Time
+	reload := func() {
+		if controlFile == "" {
+			return
+		}
+		info, err := os.Stat(controlFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				klog.Infof("stat control file: %s failed: %v\n", controlFile, err)
+			}
+			return
+		}
+		if info.ModTime().Equal(modTime) {
+			return
+		}
+		limits, err := LoadControlFile(controlFile)
+		if err != nil {
+			klog.Infof("%v\n", err)
+			return
+		}
+		modTime = info.ModTime()
+		l.SetLimits(limits)
+	}
+	reload()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reload()
+		case factor := <-signals:
+			limits := l.Limits()
+			limits.DocsPerSec *= factor
+			limits.BytesPerSec *= factor
+			l.SetLimits(limits)
+		}
+	}
+}
+
+// IgnoreSignals traps SIGUSR1 and SIGUSR2 until ctx is done when no limiter runs, so they do not kill
+// the process, and logs that throttling is off
+func IgnoreSignals(ctx context.Context) {
+	signals, stop := notifySignals()
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			klog.Infof("throttling is off, ignore signal, set --max-docs-per-sec, --max-bytes-per-sec or --throttle-file to enable it\n")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Limits max documents and bytes per second, a limit <= 0 is unlimited
+type Limits struct {
+	DocsPerSec  float64 `json:"max_docs_per_sec"`
+	BytesPerSec float64 `json:"max_bytes_per_sec"`
+}
+
+// Unlimited reports whether no limit is set
+func (l Limits) Unlimited() bool {
+	return l.DocsPerSec <= 0 && l.BytesPerSec <= 0
+}
+
+// Limiter throttles documents and bytes shared by concurrent readers or writers,
+// the limits can be changed while it is in use. A nil Limiter never waits.
+type Limiter struct {
+	mu     sync.Mutex
+	limits Limits
+	// next is when the next caller may proceed, every call pushes it by the time its cost takes at the limits
+	next time.Time
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{limits: limits}
+}
+
+// Limits returns the current limits
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// SetLimits replaces the limits, callers already waiting keep their turn
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits != limits {
+		klog.Infof("rate limits changed, max docs per sec: %v, max bytes per sec: %v\n", limits.DocsPerSec, limits.BytesPerSec)
+	}
+	l.limits = limits
+}
+
+// Wait blocks until docs documents of bytes bytes may pass, or ctx is done
+func (l *Limiter) Wait(ctx context.Context, docs, bytes int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	cost := time.Duration(0)
+	if l.limits.DocsPerSec > 0 {
+		cost = max(cost, time.Duration(float64(docs)/l.limits.DocsPerSec*float64(time.Second)))
+	}
+	if l.limits.BytesPerSec > 0 {
+		cost = max(cost, time.Duration(float64(bytes)/l.limits.BytesPerSec*float64(time.Second)))
+	}
+	now := time.Now()
+	// idle time is not saved up for a burst
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(cost)
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	klog.V(5).Infof("throttled for %v\n", wait)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package ratelimit
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals returns the factor to scale the limits by for every SIGUSR1 (0.5) and SIGUSR2 (2)
+func notifySignals() (<-chan float64, func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	factors := make(chan float64)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigs:
+				factor := 2.0
+				if sig == syscall.SIGUSR1 {
+					factor = 0.5
+				}
+				select {
+				case factors <- factor:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return factors, func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
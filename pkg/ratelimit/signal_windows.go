@@ -0,0 +1,14 @@
+//go:build windows
+
+/*
+Copyright 2021 Shine Xia <shine.xgh@gmail.com>.
+
+Licensed under the MIT License.
+*/
+
+package ratelimit
+
+// notifySignals never fires, there are no user signals on windows, use the control file instead
+func notifySignals() (<-chan float64, func()) {
+	return nil, func() {}
+}